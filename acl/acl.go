@@ -1,5 +1,10 @@
 package acl
 
+import (
+	"context"
+	"io"
+)
+
 type Username string
 type Password string
 
@@ -11,6 +16,19 @@ const (
 	DELETE
 )
 
+func (p Permission) String() string {
+	switch p {
+	case READ:
+		return "read"
+	case WRITE:
+		return "write"
+	case DELETE:
+		return "delete"
+	}
+
+	return "unknown"
+}
+
 const (
 	Anonymous Username = Username("")
 )
@@ -20,3 +38,42 @@ type Driver interface {
 
 	CanAccess(username Username, namespace, repo string, perm Permission) (bool, error)
 }
+
+// Close releases what d holds, say, file watchers or db pools, if d is an io.Closer
+// drivers need not implement Close
+func Close(d Driver) error {
+	if c, ok := d.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// ContextBinder is implemented by drivers which use the context of the request they serve, say, to log its request id
+type ContextBinder interface {
+	// WithContext returns the driver serving the request of ctx
+	WithContext(ctx context.Context) Driver
+}
+
+// WithContext returns d serving the request of ctx, d itself if it is not a ContextBinder
+func WithContext(d Driver, ctx context.Context) Driver {
+	if b, ok := d.(ContextBinder); ok {
+		return b.WithContext(ctx)
+	}
+
+	return d
+}
+
+// Checker is implemented by drivers which can tell if they work, say, db reachable
+type Checker interface {
+	Check() error
+}
+
+// Check runs d's self check, nil if d is not a Checker
+func Check(d Driver) error {
+	if c, ok := d.(Checker); ok {
+		return c.Check()
+	}
+
+	return nil
+}
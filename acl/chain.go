@@ -0,0 +1,97 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+)
+
+// Chain tries drivers in order, the first accepting a credential grants the session
+// a driver failing does not lock out users of the others, its error is returned only if none accepts
+type Chain []Driver
+
+func (c Chain) Authenticate(cred *Credential) (Session, error) {
+
+	var first error
+
+	for _, d := range c {
+		s, err := Login(d, cred)
+
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+
+			continue
+		}
+
+		if s != nil {
+			return s, nil
+		}
+	}
+
+	return nil, first
+}
+
+func (c Chain) CanLogin(username Username, password Password) (bool, error) {
+	s, err := c.Authenticate(&Credential{Username: username, Password: password})
+	return s != nil, err
+}
+
+// CanAccess is for usernames not logged in by the chain, say, anonymous or verified by a client certificate,
+// any driver granting is enough
+func (c Chain) CanAccess(username Username, namespace, repo string, perm Permission) (bool, error) {
+
+	var first error
+
+	for _, d := range c {
+		ok, err := d.CanAccess(username, namespace, repo, perm)
+
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+
+			continue
+		}
+
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, first
+}
+
+func (c Chain) WithContext(ctx context.Context) Driver {
+
+	bound := make(Chain, len(c))
+
+	for i, d := range c {
+		bound[i] = WithContext(d, ctx)
+	}
+
+	return bound
+}
+
+func (c Chain) Check() error {
+	for i, d := range c {
+		if err := Check(d); err != nil {
+			return fmt.Errorf("#%v in chain: %v", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (c Chain) Close() error {
+
+	var first error
+
+	for _, d := range c {
+		if err := Close(d); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
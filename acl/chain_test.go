@@ -0,0 +1,81 @@
+package acl
+
+import (
+	"fmt"
+	"testing"
+)
+
+// users logs in users with their password, granting read of the namespace of their name
+type users map[Username]Password
+
+func (u users) CanLogin(username Username, password Password) (bool, error) {
+	p, ok := u[username]
+	return ok && p == password, nil
+}
+
+func (u users) CanAccess(username Username, namespace, repo string, perm Permission) (bool, error) {
+	_, ok := u[username]
+	return ok && string(username) == namespace && perm == READ, nil
+}
+
+type broken struct{}
+
+func (broken) CanLogin(username Username, password Password) (bool, error) {
+	return false, fmt.Errorf("down")
+}
+
+func (broken) CanAccess(username Username, namespace, repo string, perm Permission) (bool, error) {
+	return false, fmt.Errorf("down")
+}
+
+func TestChain(t *testing.T) {
+
+	c := Chain{
+		broken{},
+		users{"staff": "s"},
+		users{"partner": "p", "staff": "other"},
+	}
+
+	for _, l := range []struct {
+		username Username
+		password Password
+		ok       bool
+	}{
+		{"staff", "s", true},
+		{"staff", "other", true},
+		{"partner", "p", true},
+		{"partner", "s", false},
+		{"nobody", "x", false},
+	} {
+		s, err := Login(c, &Credential{Username: l.username, Password: l.password})
+
+		if l.ok {
+			if err != nil || s == nil {
+				t.Fatalf("%v/%v rejected: %v", l.username, l.password, err)
+			}
+
+			if ok, _ := s.CanAccess(string(l.username), "repo", READ); !ok {
+				t.Fatalf("%v cannot read its namespace", l.username)
+			}
+
+			continue
+		}
+
+		if s != nil {
+			t.Fatalf("%v/%v accepted", l.username, l.password)
+		}
+
+		// none accepted, the broken driver is why
+		if err == nil {
+			t.Fatalf("%v/%v: error of the broken driver lost", l.username, l.password)
+		}
+	}
+
+	if ok, err := c.CanAccess("partner", "partner", "repo", READ); !ok || err != nil {
+		t.Fatalf("partner cannot read its namespace: %v", err)
+	}
+
+	if ok, _ := c.CanAccess("partner", "staff", "repo", READ); ok {
+		t.Fatal("partner can read staff")
+	}
+}
@@ -2,15 +2,14 @@ package derelict
 
 import (
 	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/driver"
 )
 
 type Driver struct {
 }
 
 func init() {
-	d := &Driver{}
-
-	acl.Register("derelict", d, func() error { return nil })
+	acl.Register("derelict", nil, func(c driver.Config) (acl.Driver, error) { return &Driver{}, nil })
 }
 
 func (d *Driver) CanLogin(username acl.Username, password acl.Password) (bool, error) {
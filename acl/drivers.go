@@ -1,31 +1,31 @@
 package acl
 
 import (
-	"fmt"
+	"github.com/tg123/docker-wicket/driver"
 )
 
-type managedDriver struct {
-	driver Driver
-	check  func() error
-}
-
-var drivers = make(map[string]managedDriver)
-
-func Load(name string) (Driver, error) {
+// Factory creates a driver from settings of one instance, a fresh one on each call,
+// so that a driver can be rebuilt on reload while the old one still serves,
+// or used many times with different settings
+type Factory func(c driver.Config) (Driver, error)
 
-	d, ok := drivers[name]
+var drivers driver.Registry[Driver]
 
-	if !ok {
-		return nil, fmt.Errorf("Driver not found")
-	}
+// Load creates an instance of driver name, options not in c are their defaults
+func Load(name string, c driver.Config) (Driver, error) {
+	return drivers.New(name, c)
+}
 
-	if err := d.check(); err != nil {
-		return nil, err
-	}
+// Drivers lists names of registered drivers
+func Drivers() []string {
+	return drivers.Names()
+}
 
-	return d.driver, nil
+// Options lists what driver name takes, false if not registered
+func Options(name string) ([]driver.Option, bool) {
+	return drivers.Options(name)
 }
 
-func Register(name string, driver Driver, check func() error) {
-	drivers[name] = managedDriver{driver, check}
+func Register(name string, options []driver.Option, factory Factory) {
+	drivers.Register(name, options, factory)
 }
@@ -0,0 +1,103 @@
+package acl
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// action names as in registry2 scopes
+var ActionPermissions = map[string]Permission{
+	"pull":   READ,
+	"push":   WRITE,
+	"delete": DELETE,
+}
+
+// Grant allows Actions on repositories whose "namespace/repo" matches Repository.
+// Repository is a path.Match pattern, say, "foo/*", or "*" for any repository
+type Grant struct {
+	Repository string   `json:"repository"`
+	Actions    []string `json:"actions"`
+}
+
+// ParseGrant parses "foo/*:pull,push"
+func ParseGrant(s string) (Grant, error) {
+	i := strings.LastIndex(s, ":")
+
+	if i <= 0 || i == len(s)-1 {
+		return Grant{}, fmt.Errorf("invalid grant %q, want repository:action[,action]", s)
+	}
+
+	g := Grant{
+		Repository: s[:i],
+		Actions:    strings.Split(s[i+1:], ","),
+	}
+
+	if _, err := path.Match(g.Repository, ""); err != nil {
+		return Grant{}, fmt.Errorf("invalid grant %q: %v", s, err)
+	}
+
+	for _, a := range g.Actions {
+		if _, ok := ActionPermissions[a]; !ok {
+			return Grant{}, fmt.Errorf("invalid grant %q: unknown action %q", s, a)
+		}
+	}
+
+	return g, nil
+}
+
+func (g Grant) String() string {
+	return fmt.Sprintf("%v:%v", g.Repository, strings.Join(g.Actions, ","))
+}
+
+func (g Grant) Allows(namespace, repo string, perm Permission) bool {
+
+	if g.Repository != "*" {
+		if ok, _ := path.Match(g.Repository, fmt.Sprintf("%v/%v", namespace, repo)); !ok {
+			return false
+		}
+	}
+
+	for _, a := range g.Actions {
+		if p, ok := ActionPermissions[a]; ok && p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+type Grants []Grant
+
+func (gs Grants) Allows(namespace, repo string, perm Permission) bool {
+	for _, g := range gs {
+		if g.Allows(namespace, repo, perm) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String and Set make Grants a repeatable flag
+func (gs *Grants) String() string {
+	s := make([]string, len(*gs))
+
+	for i, g := range *gs {
+		s[i] = g.String()
+	}
+
+	return strings.Join(s, " ")
+}
+
+func (gs *Grants) Set(value string) error {
+	g, err := ParseGrant(value)
+
+	if err != nil {
+		return err
+	}
+
+	*gs = append(*gs, g)
+
+	return nil
+}
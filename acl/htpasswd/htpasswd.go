@@ -1,57 +1,95 @@
 package htpasswd
 
 import (
-	"github.com/docker/docker/pkg/mflag"
+	"fmt"
+	"log/slog"
+
 	"github.com/tg123/go-htpasswd"
 
 	"gopkg.in/fsnotify.v1"
 
 	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/driver"
 )
 
 type Driver struct {
-	htp *htpasswd.HtpasswdFile
+	htp     *htpasswd.File
+	watcher *fsnotify.Watcher
 }
 
 func init() {
-	d := &Driver{}
+	acl.Register("htpasswd", []driver.Option{
+		{Name: "file", Usage: "File path to htpasswd format file"},
+	}, func(c driver.Config) (acl.Driver, error) {
+		file := c.String("file")
+
+		if file == "" {
+			return nil, fmt.Errorf("htpasswd file not set")
+		}
 
-	var file string
+		return New(file)
+	})
+}
 
-	mflag.StringVar(&file, []string{"-acl_htpasswd_file"}, "", "File path to htpasswd format file")
+// New loads file and reloads it when written, until Close
+func New(file string) (*Driver, error) {
 
-	acl.Register("htpasswd", d, func() error {
+	htp, err := htpasswd.New(file, htpasswd.DefaultSystems, nil)
 
-		htp, err := htpasswd.New(file, htpasswd.DefaultSystems, nil)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			return err
-		}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
 
-		d.htp = htp
+	err = watcher.Add(file)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
 
-		watcher, err := fsnotify.NewWatcher()
-		if err != nil {
-			return err
-		}
-		defer watcher.Close()
+	d := &Driver{htp, watcher}
 
-		go func() {
-			for {
-				event := <-watcher.Events
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					d.htp.Reload(nil)
+	// Events is closed by Close
+	go func() {
+		for event := range watcher.Events {
+			if event.Op&fsnotify.Write == fsnotify.Write {
+				if err := d.htp.Reload(nil); err != nil {
+					slog.Warn("Cannot reload htpasswd file", "path", file, "err", err)
+				} else {
+					slog.Info("Reloaded htpasswd file", "path", file)
 				}
 			}
-		}()
+		}
+	}()
 
-		err = watcher.Add(file)
-		if err != nil {
-			return err
+	// fsnotify blocks on a full Errors channel, closed by Close as well
+	go func() {
+		for err := range watcher.Errors {
+			slog.Warn("Error watching htpasswd file", "path", file, "err", err)
 		}
+	}()
 
+	return d, nil
+}
+
+func (d *Driver) Check() error {
+	if d.htp == nil {
+		return fmt.Errorf("htpasswd file not loaded")
+	}
+
+	return nil
+}
+
+func (d *Driver) Close() error {
+	if d.watcher == nil {
 		return nil
-	})
+	}
+
+	return d.watcher.Close()
 }
 
 func (d *Driver) CanLogin(username acl.Username, password acl.Password) (bool, error) {
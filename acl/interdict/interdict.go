@@ -2,15 +2,14 @@ package interdict
 
 import (
 	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/driver"
 )
 
 type Driver struct {
 }
 
 func init() {
-	d := &Driver{}
-
-	acl.Register("interdict", d, func() error { return nil })
+	acl.Register("interdict", nil, func(c driver.Config) (acl.Driver, error) { return &Driver{}, nil })
 }
 
 func (d *Driver) CanLogin(username acl.Username, password acl.Password) (bool, error) {
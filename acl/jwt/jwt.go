@@ -0,0 +1,200 @@
+// Package jwt verifies JWTs signed by others, say, OIDC ID tokens, for acl drivers
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// allowed clock skew
+const Leeway = 60 * time.Second
+
+type Header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+type Token struct {
+	Header Header
+
+	payload   []byte
+	signed    string
+	signature []byte
+}
+
+// Audience is either a string or an array of strings in json
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var l []string
+
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+
+	*a = Audience(l)
+
+	return nil
+}
+
+// Claims are the registered claims, embed it to get others
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	Expiry    int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// Parse splits and decodes a compact JWT, nothing is verified
+func Parse(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("jwt: malformed token")
+	}
+
+	h, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("jwt: malformed header: %v", err)
+	}
+
+	t := &Token{
+		signed: parts[0] + "." + parts[1],
+	}
+
+	if err := json.Unmarshal(h, &t.Header); err != nil {
+		return nil, fmt.Errorf("jwt: malformed header: %v", err)
+	}
+
+	if t.payload, err = decodeSegment(parts[1]); err != nil {
+		return nil, fmt.Errorf("jwt: malformed payload: %v", err)
+	}
+
+	if t.signature, err = decodeSegment(parts[2]); err != nil {
+		return nil, fmt.Errorf("jwt: malformed signature: %v", err)
+	}
+
+	return t, nil
+}
+
+// Claims unmarshals the payload into v
+func (t *Token) Claims(v interface{}) error {
+	return json.Unmarshal(t.payload, v)
+}
+
+var hashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// Verify checks the signature against any of keys
+func (t *Token) Verify(keys []crypto.PublicKey) error {
+
+	h, ok := hashes[t.Header.Algorithm]
+
+	if !ok {
+		return fmt.Errorf("jwt: unsupported algorithm %q", t.Header.Algorithm)
+	}
+
+	hasher := h.New()
+	hasher.Write([]byte(t.signed))
+	digest := hasher.Sum(nil)
+
+	for _, k := range keys {
+		switch k := k.(type) {
+		case *rsa.PublicKey:
+			if t.Header.Algorithm[0] == 'R' && rsa.VerifyPKCS1v15(k, h, digest, t.signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+
+			if t.Header.Algorithm[0] != 'E' || len(t.signature) != 2*size {
+				continue
+			}
+
+			r := new(big.Int).SetBytes(t.signature[:size])
+			s := new(big.Int).SetBytes(t.signature[size:])
+
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("jwt: signature verification failed")
+}
+
+// Validate checks issuer, audience and time, empty issuers or audiences are not checked
+func (c *Claims) Validate(issuers, audiences []string, now time.Time) error {
+
+	if len(issuers) > 0 && !contains(issuers, c.Issuer) {
+		return fmt.Errorf("jwt: untrusted issuer %q", c.Issuer)
+	}
+
+	if len(audiences) > 0 {
+		ok := false
+
+		for _, a := range c.Audience {
+			if contains(audiences, a) {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return fmt.Errorf("jwt: audience %q not accepted", []string(c.Audience))
+		}
+	}
+
+	if c.Expiry == 0 {
+		return fmt.Errorf("jwt: no expiration")
+	}
+
+	if now.Add(-Leeway).Unix() >= c.Expiry {
+		return fmt.Errorf("jwt: expired")
+	}
+
+	if c.NotBefore != 0 && now.Add(Leeway).Unix() < c.NotBefore {
+		return fmt.Errorf("jwt: not valid yet")
+	}
+
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
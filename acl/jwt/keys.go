@@ -0,0 +1,367 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySet maps key ids to public keys, keys without id are tried for every token
+type KeySet struct {
+	keys      map[string]crypto.PublicKey
+	anonymous []crypto.PublicKey
+}
+
+// Lookup returns keys which could have signed a token with kid
+func (s *KeySet) Lookup(kid string) []crypto.PublicKey {
+
+	if k, ok := s.keys[kid]; ok && kid != "" {
+		return []crypto.PublicKey{k}
+	}
+
+	if kid == "" {
+		l := make([]crypto.PublicKey, 0, len(s.keys)+len(s.anonymous))
+
+		for _, k := range s.keys {
+			l = append(l, k)
+		}
+
+		return append(l, s.anonymous...)
+	}
+
+	return s.anonymous
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		c, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: c,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// ParseJWKS parses a JSON Web Key Set, encryption keys and unknown key types are ignored
+func ParseJWKS(b []byte) (*KeySet, error) {
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("jwt: bad jwks: %v", err)
+	}
+
+	s := &KeySet{keys: make(map[string]crypto.PublicKey)}
+
+	for _, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+
+		pk, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+
+		if k.Kid == "" {
+			s.anonymous = append(s.anonymous, pk)
+		} else {
+			s.keys[k.Kid] = pk
+		}
+	}
+
+	if len(s.keys)+len(s.anonymous) == 0 {
+		return nil, fmt.Errorf("jwt: no usable key in jwks")
+	}
+
+	return s, nil
+}
+
+// ParsePEM parses public keys and certificates in PEM, all keys are anonymous
+func ParsePEM(b []byte) (*KeySet, error) {
+
+	s := &KeySet{keys: make(map[string]crypto.PublicKey)}
+
+	for {
+		var block *pem.Block
+
+		block, b = pem.Decode(b)
+
+		if block == nil {
+			break
+		}
+
+		var pk crypto.PublicKey
+		var err error
+
+		switch block.Type {
+		case "PUBLIC KEY":
+			pk, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			pk, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+
+			cert, err = x509.ParseCertificate(block.Bytes)
+
+			if err == nil {
+				pk = cert.PublicKey
+			}
+		default:
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("jwt: bad %v: %v", block.Type, err)
+		}
+
+		s.anonymous = append(s.anonymous, pk)
+	}
+
+	if len(s.anonymous) == 0 {
+		return nil, fmt.Errorf("jwt: no public key found in PEM")
+	}
+
+	return s, nil
+}
+
+// how often keys from a url are refreshed, and at most how often an unknown kid or a failed refresh can trigger a fetch
+const (
+	refreshInterval = time.Hour
+	missInterval    = time.Minute
+)
+
+// KeySource loads keys from a local file or an http(s) url, in JWKS or PEM
+// if a refresh fails, the keys fetched last keep being used
+type KeySource struct {
+	Location string
+
+	Client *http.Client
+
+	lock    sync.Mutex
+	keys    *KeySet
+	fetched time.Time // last successful fetch
+	tried   time.Time // last fetch, successful or not
+	loading *loading
+}
+
+// a fetch in flight, callers of refresh meanwhile wait for it instead of fetching again
+type loading struct {
+	done chan struct{}
+	err  error
+}
+
+func NewKeySource(location string) *KeySource {
+	return &KeySource{
+		Location: location,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *KeySource) remote() bool {
+	return strings.HasPrefix(s.Location, "http://") || strings.HasPrefix(s.Location, "https://")
+}
+
+func (s *KeySource) fetch() (*KeySet, error) {
+
+	var b []byte
+	var err error
+
+	if s.remote() {
+		var resp *http.Response
+
+		resp, err = s.Client.Get(s.Location)
+		if err != nil {
+			return nil, err
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("jwt: fetching %v: %v", s.Location, resp.Status)
+		}
+
+		b, err = ioutil.ReadAll(resp.Body)
+	} else {
+		b, err = ioutil.ReadFile(s.Location)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(string(b)), "-----BEGIN") {
+		return ParsePEM(b)
+	}
+
+	return ParseJWKS(b)
+}
+
+// Load (re)loads keys now
+func (s *KeySource) Load() error {
+	return s.refresh()
+}
+
+// refresh fetches keys without holding the lock, one fetch at a time
+func (s *KeySource) refresh() error {
+
+	s.lock.Lock()
+
+	l := s.loading
+
+	if l == nil {
+		l = &loading{done: make(chan struct{})}
+		s.loading = l
+
+		s.lock.Unlock()
+
+		keys, err := s.fetch()
+
+		s.lock.Lock()
+
+		s.tried = time.Now()
+
+		if err == nil {
+			s.keys = keys
+			s.fetched = s.tried
+		}
+
+		l.err = err
+		s.loading = nil
+		close(l.done)
+	}
+
+	s.lock.Unlock()
+
+	<-l.done
+
+	return l.err
+}
+
+func (s *KeySource) state() (keys *KeySet, fetched, tried time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.keys, s.fetched, s.tried
+}
+
+// Lookup returns keys for kid, refreshing the set if it is stale or kid is unknown
+func (s *KeySource) Lookup(kid string) ([]crypto.PublicKey, error) {
+
+	keys, fetched, tried := s.state()
+
+	stale := s.remote() && time.Since(fetched) > refreshInterval && time.Since(tried) > missInterval
+
+	if keys == nil || stale {
+		if err := s.refresh(); err != nil {
+			if keys == nil {
+				return nil, err
+			}
+
+			slog.Warn("Cannot refresh keys, using cached ones", "location", s.Location, "err", err)
+		}
+
+		keys, _, tried = s.state()
+	}
+
+	found := keys.Lookup(kid)
+
+	// keys may be rotated
+	if len(found) == 0 && s.remote() && time.Since(tried) > missInterval {
+		if err := s.refresh(); err != nil {
+			slog.Warn("Cannot refresh keys for unknown kid", "location", s.Location, "kid", kid, "err", err)
+			return found, nil
+		}
+
+		keys, _, _ = s.state()
+		found = keys.Lookup(kid)
+	}
+
+	return found, nil
+}
+
+// VerifyWith parses raw, verifies its signature against keys from s, and unmarshals its claims into v
+func (s *KeySource) VerifyWith(raw string, v interface{}) error {
+
+	t, err := Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	keys, err := s.Lookup(t.Header.KeyID)
+	if err != nil {
+		return err
+	}
+
+	if err := t.Verify(keys); err != nil {
+		return err
+	}
+
+	return t.Claims(v)
+}
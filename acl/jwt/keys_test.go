@@ -0,0 +1,248 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwksServer serves a JWKS with a key per kid, hits counts fetches, fail makes it answer 500
+type jwksServer struct {
+	*httptest.Server
+
+	hits atomic.Int32
+	fail atomic.Bool
+
+	lock sync.Mutex
+	kids []string
+	wait chan struct{}
+}
+
+func newJWKSServer(t *testing.T, kids ...string) *jwksServer {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := base64.RawURLEncoding.EncodeToString(key.X.Bytes())
+	y := base64.RawURLEncoding.EncodeToString(key.Y.Bytes())
+
+	s := &jwksServer{kids: kids}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits.Add(1)
+
+		s.lock.Lock()
+		wait := s.wait
+		kids := s.kids
+		s.lock.Unlock()
+
+		if wait != nil {
+			<-wait
+		}
+
+		if s.fail.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprint(w, `{"keys": [`)
+
+		for i, kid := range kids {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+
+			fmt.Fprintf(w, `{"kty": "EC", "crv": "P-256", "kid": %q, "x": %q, "y": %q}`, kid, x, y)
+		}
+
+		fmt.Fprint(w, `]}`)
+	}))
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *jwksServer) setKids(kids ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.kids = kids
+}
+
+// age pretends the last fetch happened d ago
+func (k *KeySource) age(d time.Duration) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.fetched = k.fetched.Add(-d)
+	k.tried = k.tried.Add(-d)
+}
+
+func TestKeySourceLookup(t *testing.T) {
+
+	srv := newJWKSServer(t, "a")
+	s := NewKeySource(srv.URL)
+
+	keys, err := s.Lookup("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 {
+		t.Fatalf("got %v keys for a, want 1", len(keys))
+	}
+
+	if _, err := s.Lookup("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := srv.hits.Load(); n != 1 {
+		t.Fatalf("fetched %v times, want 1", n)
+	}
+}
+
+func TestKeySourceKeepsKeysOnError(t *testing.T) {
+
+	srv := newJWKSServer(t, "a")
+	s := NewKeySource(srv.URL)
+
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.fail.Store(true)
+	s.age(2 * refreshInterval)
+
+	keys, err := s.Lookup("a")
+	if err != nil {
+		t.Fatalf("stale keys with a failing refresh: %v", err)
+	}
+
+	if len(keys) != 1 {
+		t.Fatalf("got %v keys for a, want the cached one", len(keys))
+	}
+
+	if n := srv.hits.Load(); n != 2 {
+		t.Fatalf("fetched %v times, want 2", n)
+	}
+
+	// the failed refresh counts as a try, no refetch on every lookup
+	if _, err := s.Lookup("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := srv.hits.Load(); n != 2 {
+		t.Fatalf("fetched %v times after a failed refresh, want 2", n)
+	}
+}
+
+func TestKeySourceFirstLoadError(t *testing.T) {
+
+	srv := newJWKSServer(t, "a")
+	srv.fail.Store(true)
+
+	if _, err := NewKeySource(srv.URL).Lookup("a"); err == nil {
+		t.Fatal("no error without any keys")
+	}
+}
+
+func TestKeySourceUnknownKid(t *testing.T) {
+
+	srv := newJWKSServer(t, "a")
+	s := NewKeySource(srv.URL)
+
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		keys, err := s.Lookup("unknown")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) != 0 {
+			t.Fatalf("got %v keys for an unknown kid", len(keys))
+		}
+	}
+
+	if n := srv.hits.Load(); n != 1 {
+		t.Fatalf("fetched %v times for unknown kids, want 1", n)
+	}
+
+	// rotated, found once the rate limit passes
+	srv.setKids("a", "b")
+	s.age(2 * missInterval)
+
+	keys, err := s.Lookup("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 {
+		t.Fatalf("got %v keys for rotated b, want 1", len(keys))
+	}
+
+	if n := srv.hits.Load(); n != 2 {
+		t.Fatalf("fetched %v times, want 2", n)
+	}
+}
+
+func TestKeySourceSingleFetch(t *testing.T) {
+
+	srv := newJWKSServer(t, "a")
+	srv.wait = make(chan struct{})
+
+	s := NewKeySource(srv.URL)
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 10)
+
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			keys, err := s.Lookup("a")
+			if err == nil && len(keys) != 1 {
+				err = fmt.Errorf("got %v keys for a", len(keys))
+			}
+
+			errs <- err
+		}()
+	}
+
+	// let the lookups pile up on the slow fetch
+	for srv.hits.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(srv.wait)
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := srv.hits.Load(); n != 1 {
+		t.Fatalf("fetched %v times for concurrent lookups, want 1", n)
+	}
+}
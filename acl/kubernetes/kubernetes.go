@@ -0,0 +1,391 @@
+package kubernetes
+
+// pods login with their service account token as password
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/acl/jwt"
+	"github.com/tg123/docker-wicket/driver"
+)
+
+const subjectPrefix = "system:serviceaccount:"
+
+type Driver struct {
+	Username  acl.Username
+	Issuers   []string
+	Audiences []string
+
+	// either keys or review is set
+	keys   *jwt.KeySource
+	review *tokenReviewer
+
+	mapping mapping
+}
+
+var options = []driver.Option{
+	{Name: "username", Usage: "Username to login with a service account token as password", Default: "serviceaccount"},
+	{Name: "keys", Usage: "Service account signing public keys, PEM or JWKS, file or url"},
+	{Name: "issuer", Usage: "Comma separated trusted issuers of projected tokens, only with keys", Default: "https://kubernetes.default.svc,https://kubernetes.default.svc.cluster.local"},
+	{Name: "audience", Usage: "Comma separated accepted audiences, pods project tokens for one of these", Default: "wicket"},
+	{Name: "token_review_url", Usage: "TokenReview endpoint, e.g. https://kubernetes.default.svc/apis/authentication.k8s.io/v1/tokenreviews, instead of keys"},
+	{Name: "token_review_bearer_file", Usage: "File of the bearer token to call TokenReview endpoint"},
+	{Name: "token_review_ca", Usage: "CA file to verify TokenReview endpoint"},
+	{Name: "mapping", Usage: "JSON file mapping service accounts to grants"},
+}
+
+func init() {
+	acl.Register("kubernetes", options, func(c driver.Config) (acl.Driver, error) {
+
+		keys := c.String("keys")
+		reviewURL := c.String("token_review_url")
+		mappingFile := c.String("mapping")
+
+		if (keys == "") == (reviewURL == "") {
+			return nil, fmt.Errorf("exactly one of keys and token review url must be set")
+		}
+
+		if mappingFile == "" {
+			return nil, fmt.Errorf("mapping file not set")
+		}
+
+		// without, any token of the cluster, legacy ones never expiring, would be a password here
+		if len(c.List("audience")) == 0 {
+			return nil, fmt.Errorf("no audience set")
+		}
+
+		m, err := loadMapping(mappingFile)
+		if err != nil {
+			return nil, err
+		}
+
+		d := &Driver{
+			Username:  acl.Username(c.String("username")),
+			Issuers:   c.List("issuer"),
+			Audiences: c.List("audience"),
+			mapping:   m,
+		}
+
+		if keys != "" {
+			d.keys = jwt.NewKeySource(keys)
+
+			if err := d.keys.Load(); err != nil {
+				return nil, err
+			}
+
+			return d, nil
+		}
+
+		d.review, err = newTokenReviewer(reviewURL, c.String("token_review_bearer_file"), c.String("token_review_ca"))
+		if err != nil {
+			return nil, err
+		}
+
+		return d, nil
+	})
+}
+
+// mapping from service account pattern to grants, say,
+//
+//	{
+//	  "system:serviceaccount:ci:builder": ["ci/*:pull,push"],
+//	  "system:serviceaccount:*:default":  ["{namespace}/*:pull"]
+//	}
+//
+// patterns are path.Match patterns, {namespace} and {name} in grants are replaced by those of the service account
+type mapping map[string][]string
+
+func loadMapping(file string) (mapping, error) {
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(mapping)
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("bad mapping file %v: %v", file, err)
+	}
+
+	for p, grants := range m {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %v", p, err)
+		}
+
+		for _, g := range grants {
+			if _, err := acl.ParseGrant(g); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// grants of system:serviceaccount:<ns>:<name>
+func (m mapping) grants(subject string) acl.Grants {
+
+	parts := strings.Split(strings.TrimPrefix(subject, subjectPrefix), ":")
+
+	if !strings.HasPrefix(subject, subjectPrefix) || len(parts) != 2 {
+		return nil
+	}
+
+	r := strings.NewReplacer("{namespace}", parts[0], "{name}", parts[1])
+
+	// stable order
+	patterns := make([]string, 0, len(m))
+
+	for p := range m {
+		patterns = append(patterns, p)
+	}
+
+	sort.Strings(patterns)
+
+	var grants acl.Grants
+
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, subject); !ok {
+			continue
+		}
+
+		for _, g := range m[p] {
+			if g, err := acl.ParseGrant(r.Replace(g)); err == nil {
+				grants = append(grants, g)
+			}
+		}
+	}
+
+	return grants
+}
+
+func (d *Driver) Check() error {
+	if d.keys != nil {
+		_, err := d.keys.Lookup("")
+		return err
+	}
+
+	return nil
+}
+
+// subject returns system:serviceaccount:<ns>:<name> of a valid token
+func (d *Driver) subject(token string) (string, error) {
+
+	if d.review != nil {
+		return d.review.review(token, d.Audiences)
+	}
+
+	var claims jwt.Claims
+
+	if err := d.keys.VerifyWith(token, &claims); err != nil {
+		return "", err
+	}
+
+	if err := claims.Validate(d.Issuers, d.Audiences, time.Now()); err != nil {
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
+func (d *Driver) Authenticate(cred *acl.Credential) (acl.Session, error) {
+
+	if cred.Username != d.Username {
+		return nil, nil
+	}
+
+	sub, err := d.subject(string(cred.Password))
+
+	if err != nil {
+		if _, ok := err.(*reviewError); ok {
+			return nil, err
+		}
+
+		// bad tokens are rejections, not errors
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(sub, subjectPrefix) {
+		return nil, nil
+	}
+
+	return &session{
+		username: acl.Username(sub),
+		grants:   d.mapping.grants(sub),
+	}, nil
+}
+
+func (d *Driver) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	s, err := d.Authenticate(&acl.Credential{
+		Username: username,
+		Password: password,
+	})
+
+	return s != nil, err
+}
+
+// grants are only known from the token, see Authenticate
+func (d *Driver) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	return false, nil
+}
+
+type session struct {
+	username acl.Username
+	grants   acl.Grants
+}
+
+func (s *session) Username() acl.Username {
+	return s.username
+}
+
+func (s *session) CanAccess(namespace, repo string, perm acl.Permission) (bool, error) {
+	return s.grants.Allows(namespace, repo, perm), nil
+}
+
+// TokenReview
+
+// reviewError means the endpoint could not answer, unlike a token it says not authenticated
+type reviewError struct {
+	err error
+}
+
+func (e *reviewError) Error() string {
+	return fmt.Sprintf("token review: %v", e.err)
+}
+
+type tokenReviewer struct {
+	url    string
+	bearer string
+	client *http.Client
+}
+
+func newTokenReviewer(url, bearerFile, caFile string) (*tokenReviewer, error) {
+
+	r := &tokenReviewer{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if bearerFile != "" {
+		b, err := ioutil.ReadFile(bearerFile)
+		if err != nil {
+			return nil, err
+		}
+
+		r.bearer = strings.TrimSpace(string(b))
+	}
+
+	if caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in %v", caFile)
+		}
+
+		r.client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+
+	return r, nil
+}
+
+type tokenReview struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Token     string   `json:"token"`
+		Audiences []string `json:"audiences,omitempty"`
+	} `json:"spec"`
+	Status struct {
+		Authenticated bool `json:"authenticated"`
+		User          struct {
+			Username string `json:"username"`
+		} `json:"user"`
+		Audiences []string `json:"audiences,omitempty"`
+		Error     string   `json:"error,omitempty"`
+	} `json:"status"`
+}
+
+func (r *tokenReviewer) review(token string, audiences []string) (string, error) {
+
+	var tr tokenReview
+
+	tr.APIVersion = "authentication.k8s.io/v1"
+	tr.Kind = "TokenReview"
+	tr.Spec.Token = token
+	tr.Spec.Audiences = audiences
+
+	b, err := json.Marshal(&tr)
+	if err != nil {
+		return "", &reviewError{err}
+	}
+
+	req, err := http.NewRequest("POST", r.url, bytes.NewReader(b))
+	if err != nil {
+		return "", &reviewError{err}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if r.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+r.bearer)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", &reviewError{err}
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", &reviewError{fmt.Errorf("%v", resp.Status)}
+	}
+
+	var result tokenReview
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", &reviewError{err}
+	}
+
+	if !result.Status.Authenticated {
+		return "", fmt.Errorf("not authenticated: %v", result.Status.Error)
+	}
+
+	// apiservers before audiences were supported leave it empty
+	if len(result.Status.Audiences) > 0 && !intersects(result.Status.Audiences, audiences) {
+		return "", fmt.Errorf("not authenticated for %q", audiences)
+	}
+
+	return result.Status.User.Username, nil
+}
+
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,231 @@
+package kubernetes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/driver"
+)
+
+const builder = "system:serviceaccount:ci:builder"
+
+func writeFile(t *testing.T, name, content string) string {
+	p := filepath.Join(t.TempDir(), name)
+
+	if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func mappingFile(t *testing.T) string {
+	return writeFile(t, "mapping.json", `{"system:serviceaccount:ci:builder": ["ci/*:pull,push"]}`)
+}
+
+func load(t *testing.T, c driver.Config) *Driver {
+	d, err := acl.Load("kubernetes", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d.(*Driver)
+}
+
+func login(t *testing.T, d *Driver, token string) acl.Session {
+	s, err := d.Authenticate(&acl.Credential{Username: "serviceaccount", Password: acl.Password(token)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func expectBuilder(t *testing.T, s acl.Session) {
+
+	if s == nil {
+		t.Fatal("token rejected")
+	}
+
+	if s.Username() != builder {
+		t.Fatalf("logged in as %v, want %v", s.Username(), builder)
+	}
+
+	if ok, _ := s.CanAccess("ci", "app", acl.WRITE); !ok {
+		t.Fatal("builder cannot push ci/app")
+	}
+
+	if ok, _ := s.CanAccess("other", "app", acl.READ); ok {
+		t.Fatal("builder can pull other/app")
+	}
+}
+
+func TestNoAudience(t *testing.T) {
+	_, err := acl.Load("kubernetes", driver.Config{
+		"token_review_url": {"http://127.0.0.1"},
+		"mapping":          {mappingFile(t)},
+		"audience":         {""},
+	})
+
+	if err == nil {
+		t.Fatal("loaded without audience")
+	}
+}
+
+// signer stands in for the apiserver signing projected tokens
+type signer struct {
+	key *ecdsa.PrivateKey
+}
+
+func newSigner(t *testing.T) *signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &signer{key}
+}
+
+func (s *signer) jwks() string {
+	return fmt.Sprintf(`{"keys": [{"kty": "EC", "crv": "P-256", "kid": "sa", "x": %q, "y": %q}]}`,
+		base64.RawURLEncoding.EncodeToString(s.key.X.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(s.key.Y.FillBytes(make([]byte, 32))))
+}
+
+func (s *signer) sign(t *testing.T, claims map[string]interface{}) string {
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"sa"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+
+	r, ss, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := append(r.FillBytes(make([]byte, 32)), ss.FillBytes(make([]byte, 32))...)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestKeys(t *testing.T) {
+
+	sa := newSigner(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sa.jwks())
+	}))
+
+	defer srv.Close()
+
+	d := load(t, driver.Config{
+		"keys":    {srv.URL},
+		"mapping": {mappingFile(t)},
+	})
+
+	exp := time.Now().Add(time.Hour).Unix()
+
+	expectBuilder(t, login(t, d, sa.sign(t, map[string]interface{}{
+		"iss": "https://kubernetes.default.svc",
+		"sub": builder,
+		"aud": []string{"wicket"},
+		"exp": exp,
+	})))
+
+	for name, claims := range map[string]map[string]interface{}{
+		"legacy": {
+			"iss": "kubernetes/serviceaccount",
+			"sub": builder,
+		},
+		"other audience": {
+			"iss": "https://kubernetes.default.svc",
+			"sub": builder,
+			"aud": []string{"https://kubernetes.default.svc"},
+			"exp": exp,
+		},
+		"expired": {
+			"iss": "https://kubernetes.default.svc",
+			"sub": builder,
+			"aud": []string{"wicket"},
+			"exp": time.Now().Add(-time.Hour).Unix(),
+		},
+	} {
+		if s := login(t, d, sa.sign(t, claims)); s != nil {
+			t.Errorf("%v token accepted", name)
+		}
+	}
+}
+
+func TestTokenReview(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("Authorization") != "Bearer reviewer" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var tr tokenReview
+
+		if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case tr.Spec.Token == "broken":
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		case tr.Spec.Token == "good" && len(tr.Spec.Audiences) == 1 && tr.Spec.Audiences[0] == "wicket":
+			tr.Status.Authenticated = true
+			tr.Status.User.Username = builder
+			tr.Status.Audiences = tr.Spec.Audiences
+		case tr.Spec.Token == "other audience":
+			tr.Status.Authenticated = true
+			tr.Status.User.Username = builder
+			tr.Status.Audiences = []string{"https://kubernetes.default.svc"}
+		default:
+			tr.Status.Error = "invalid token"
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&tr)
+	}))
+
+	defer srv.Close()
+
+	d := load(t, driver.Config{
+		"token_review_url":         {srv.URL},
+		"token_review_bearer_file": {writeFile(t, "token", "reviewer\n")},
+		"mapping":                  {mappingFile(t)},
+	})
+
+	expectBuilder(t, login(t, d, "good"))
+
+	for _, token := range []string{"bad", "other audience"} {
+		if s := login(t, d, token); s != nil {
+			t.Errorf("%v token accepted", token)
+		}
+	}
+
+	if _, err := d.Authenticate(&acl.Credential{Username: "serviceaccount", Password: "broken"}); err == nil {
+		t.Fatal("no error when the endpoint is down")
+	}
+}
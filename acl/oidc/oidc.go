@@ -0,0 +1,246 @@
+package oidc
+
+// docker login -u oidc -p <ID token or CI job JWT>
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/acl/jwt"
+	"github.com/tg123/docker-wicket/driver"
+)
+
+type Driver struct {
+	Username  acl.Username
+	Audiences []string
+	Claims    []string
+	Actions   []string
+
+	// issuer -> keys
+	issuers map[string]*jwt.KeySource
+}
+
+var options = []driver.Option{
+	{Name: "issuer", Usage: "Trusted issuer and optional jwks file or url, e.g. https://gitlab.example.com,/path/to/jwks.json, can be repeated", Repeated: true},
+	{Name: "audience", Usage: "Comma separated accepted audiences"},
+	{Name: "username", Usage: "Username to login with a token as password", Default: "oidc"},
+	{Name: "claims", Usage: "Comma separated claims whose values are namespaces or repositories the token can access, e.g. groups,project_path", Default: "groups"},
+	{Name: "actions", Usage: "Comma separated actions allowed on the namespaces", Default: "pull,push"},
+}
+
+func init() {
+	acl.Register("oidc", options, func(c driver.Config) (acl.Driver, error) {
+
+		// each is issuer[,jwks file or url]
+		issuers := c.Strings("issuer")
+
+		if len(issuers) == 0 {
+			return nil, fmt.Errorf("no issuer set")
+		}
+
+		if len(c.List("audience")) == 0 {
+			return nil, fmt.Errorf("no audience set")
+		}
+
+		d := &Driver{
+			Username:  acl.Username(c.String("username")),
+			Audiences: c.List("audience"),
+			Claims:    c.List("claims"),
+			Actions:   c.List("actions"),
+			issuers:   make(map[string]*jwt.KeySource),
+		}
+
+		for _, a := range d.Actions {
+			if _, ok := acl.ActionPermissions[a]; !ok {
+				return nil, fmt.Errorf("unknown action %q", a)
+			}
+		}
+
+		for _, v := range issuers {
+			parts := strings.SplitN(v, ",", 2)
+
+			issuer := strings.TrimRight(parts[0], "/")
+
+			var location string
+
+			if len(parts) == 2 {
+				location = parts[1]
+			} else {
+				var err error
+
+				location, err = discover(issuer)
+
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			keys := jwt.NewKeySource(location)
+
+			if err := keys.Load(); err != nil {
+				return nil, fmt.Errorf("cannot load keys of %v: %v", issuer, err)
+			}
+
+			d.issuers[issuer] = keys
+		}
+
+		return d, nil
+	})
+}
+
+func (d *Driver) Check() error {
+	for issuer, keys := range d.issuers {
+		if _, err := keys.Lookup(""); err != nil {
+			return fmt.Errorf("keys of %v: %v", issuer, err)
+		}
+	}
+
+	return nil
+}
+
+// jwks_uri from OpenID Provider Configuration
+func discover(issuer string) (string, error) {
+
+	resp, err := http.Get(issuer + "/.well-known/openid-configuration")
+
+	if err != nil {
+		return "", fmt.Errorf("cannot discover %v: %v", issuer, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot discover %v: %v", issuer, resp.Status)
+	}
+
+	var conf struct {
+		JwksURI string `json:"jwks_uri"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&conf); err != nil {
+		return "", fmt.Errorf("cannot discover %v: %v", issuer, err)
+	}
+
+	if conf.JwksURI == "" {
+		return "", fmt.Errorf("cannot discover %v: no jwks_uri", issuer)
+	}
+
+	return conf.JwksURI, nil
+}
+
+// verify returns claims of a valid token
+func (d *Driver) verify(raw string) (map[string]interface{}, error) {
+
+	t, err := jwt.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var std jwt.Claims
+
+	if err := t.Claims(&std); err != nil {
+		return nil, err
+	}
+
+	keys, ok := d.issuers[strings.TrimRight(std.Issuer, "/")]
+
+	if !ok {
+		return nil, fmt.Errorf("jwt: untrusted issuer %q", std.Issuer)
+	}
+
+	if err := std.Validate(nil, d.Audiences, time.Now()); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+
+	if err := keys.VerifyWith(raw, &claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// claim values are names, not patterns, a group named "*" must not grant everything
+var literal = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
+// grants from claim values, value can be a string or an array of strings
+// each value v grants v/* and v itself, so both groups (namespace) and project_path (namespace/repo) work
+func (d *Driver) grants(claims map[string]interface{}) acl.Grants {
+
+	var grants acl.Grants
+
+	add := func(v interface{}) {
+		if s, ok := v.(string); ok && s != "" {
+			s = literal.Replace(s)
+			grants = append(grants,
+				acl.Grant{Repository: s, Actions: d.Actions},
+				acl.Grant{Repository: s + "/*", Actions: d.Actions},
+			)
+		}
+	}
+
+	for _, c := range d.Claims {
+		switch v := claims[c].(type) {
+		case []interface{}:
+			for _, i := range v {
+				add(i)
+			}
+		default:
+			add(v)
+		}
+	}
+
+	return grants
+}
+
+func (d *Driver) Authenticate(cred *acl.Credential) (acl.Session, error) {
+
+	if cred.Username != d.Username {
+		return nil, nil
+	}
+
+	// bad tokens are rejections, not errors
+	claims, err := d.verify(string(cred.Password))
+	if err != nil {
+		return nil, nil
+	}
+
+	sub, _ := claims["sub"].(string)
+
+	return &session{
+		username: acl.Username(sub),
+		grants:   d.grants(claims),
+	}, nil
+}
+
+func (d *Driver) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	s, err := d.Authenticate(&acl.Credential{
+		Username: username,
+		Password: password,
+	})
+
+	return s != nil, err
+}
+
+// what a token can access is only known from the token, see Authenticate
+func (d *Driver) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	return false, nil
+}
+
+type session struct {
+	username acl.Username
+	grants   acl.Grants
+}
+
+func (s *session) Username() acl.Username {
+	return s.username
+}
+
+func (s *session) CanAccess(namespace, repo string, perm acl.Permission) (bool, error) {
+	return s.grants.Allows(namespace, repo, perm), nil
+}
@@ -0,0 +1,50 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/tg123/docker-wicket/acl"
+)
+
+func TestGrants(t *testing.T) {
+
+	d := &Driver{
+		Claims:  []string{"groups", "project_path"},
+		Actions: []string{"pull"},
+	}
+
+	s := &session{grants: d.grants(map[string]interface{}{
+		"groups":       []interface{}{"team", "*", "a?c", "[x]", 42},
+		"project_path": "foo/app",
+		"email":        "someone@example.com",
+	})}
+
+	for _, c := range []struct {
+		namespace string
+		repo      string
+		perm      acl.Permission
+		want      bool
+	}{
+		{"team", "app", acl.READ, true},
+		{"team", "app", acl.WRITE, false},
+		{"foo", "app", acl.READ, true},
+		{"foo", "other", acl.READ, false},
+		{"other", "app", acl.READ, false},
+
+		// metacharacters in claim values match only themselves
+		{"*", "app", acl.READ, true},
+		{"abc", "app", acl.READ, false},
+		{"a?c", "app", acl.READ, true},
+		{"x", "app", acl.READ, false},
+		{"[x]", "app", acl.READ, true},
+	} {
+		got, err := s.CanAccess(c.namespace, c.repo, c.perm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != c.want {
+			t.Errorf("%v/%v %v: got %v, want %v", c.namespace, c.repo, c.perm, got, c.want)
+		}
+	}
+}
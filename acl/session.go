@@ -0,0 +1,77 @@
+package acl
+
+// Credential is what a client presents when logging in
+type Credential struct {
+	Username Username
+	Password Password
+
+	// ip of the client, for drivers which record where a credential is used
+	RemoteAddr string
+
+	// Username is already proven, say, by a client certificate, Password is not checked
+	Verified bool
+}
+
+// Session is a logged in identity, it may be granted less than the username alone,
+// say, a token restricted to pull
+type Session interface {
+	Username() Username
+
+	CanAccess(namespace, repo string, perm Permission) (bool, error)
+}
+
+// ByUsername tells if s has what its username alone is granted, so that the username can stand for it later,
+// say, as owner of a personal access token, false for sessions granted by the credential, say, an OIDC token
+func ByUsername(s Session) bool {
+	b, ok := s.(interface{ ByUsername() bool })
+	return ok && b.ByUsername()
+}
+
+// Authenticator is implemented by drivers whose grants depend on the credential presented
+type Authenticator interface {
+	// Authenticate returns nil Session if the credential is rejected
+	Authenticate(cred *Credential) (Session, error)
+}
+
+// Login authenticates cred against d, via Authenticate if d is an Authenticator, or CanLogin
+// a nil Session means the credential is rejected, a Verified cred is always accepted
+func Login(d Driver, cred *Credential) (Session, error) {
+
+	if cred.Verified {
+		return NewSession(d, cred.Username), nil
+	}
+
+	if a, ok := d.(Authenticator); ok {
+		return a.Authenticate(cred)
+	}
+
+	ok, err := d.CanLogin(cred.Username, cred.Password)
+
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return NewSession(d, cred.Username), nil
+}
+
+// NewSession returns a Session which has whatever d grants to username
+func NewSession(d Driver, username Username) Session {
+	return &driverSession{d, username}
+}
+
+type driverSession struct {
+	driver   Driver
+	username Username
+}
+
+func (s *driverSession) Username() Username {
+	return s.username
+}
+
+func (s *driverSession) ByUsername() bool {
+	return true
+}
+
+func (s *driverSession) CanAccess(namespace, repo string, perm Permission) (bool, error) {
+	return s.driver.CanAccess(s.username, namespace, repo, perm)
+}
@@ -0,0 +1,41 @@
+// Package atomicfile writes files so that readers see either the old or the new content, never a partial one
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile is like ioutil.WriteFile, but writes to a temp file in the same dir and renames it over filename
+func WriteFile(filename string, data []byte, perm os.FileMode) error {
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+
+	if err != nil {
+		return err
+	}
+
+	// no-op after a successful rename
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
@@ -0,0 +1,153 @@
+// Package audit records authentication and authorization decisions as structured json
+// no password or token material is ever put into an Event
+package audit
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// event types
+const (
+	Login  = "login"
+	Access = "access"
+	Token  = "token"
+)
+
+// results
+const (
+	Success = "success"
+	Failure = "failure"
+	Error   = "error"
+)
+
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	API  string    `json:"api"`
+
+	// username presented, and the identity it logged in as, they differ for token based drivers
+	Username string `json:"username,omitempty"`
+	Identity string `json:"identity,omitempty"`
+
+	// basic, certificate or anonymous
+	Method string `json:"method,omitempty"`
+
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+
+	// scope requested, say, repository samalba/my-app
+	ScopeType string   `json:"scope_type,omitempty"`
+	ScopeName string   `json:"scope_name,omitempty"`
+	Granted   []string `json:"granted,omitempty"`
+	Denied    []string `json:"denied,omitempty"`
+
+	TokenID string `json:"jti,omitempty"`
+
+	ClientIP  string `json:"client_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+type Sink interface {
+	Write(e *Event) error
+
+	Close() error
+}
+
+// SinkFunc makes a func a Sink, say, a hook of a program embedding wicket
+type SinkFunc func(e *Event) error
+
+func (f SinkFunc) Write(e *Event) error {
+	return f(e)
+}
+
+func (f SinkFunc) Close() error {
+	return nil
+}
+
+// Logger writes events to all sinks, a nil Logger drops everything
+type Logger struct {
+	sinks []Sink
+}
+
+func New(sinks ...Sink) *Logger {
+	return &Logger{sinks}
+}
+
+// Open creates a Logger from sink urls, say,
+//
+//	file:///var/log/wicket/audit.log?max_size_mb=100&max_backups=5
+//	syslog:///?tag=docker-wicket   syslog://127.0.0.1:514?tag=docker-wicket  (udp)  syslog+tcp://...
+//	http://127.0.0.1:8080/audit    https://...
+func Open(urls []string) (*Logger, error) {
+
+	l := &Logger{}
+
+	for _, s := range urls {
+		u, err := url.Parse(s)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("bad audit sink %q: %v", s, err)
+		}
+
+		var sink Sink
+
+		switch {
+		case u.Scheme == "file":
+			sink, err = newFileSink(u)
+		case strings.HasPrefix(u.Scheme, "syslog"):
+			sink, err = newSyslogSink(u)
+		case u.Scheme == "http" || u.Scheme == "https":
+			sink, err = newWebhookSink(u)
+		default:
+			err = fmt.Errorf("unknown scheme")
+		}
+
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("bad audit sink %q: %v", s, err)
+		}
+
+		l.sinks = append(l.sinks, sink)
+	}
+
+	return l, nil
+}
+
+// Log never fails the request, errors from sinks are logged
+func (l *Logger) Log(e *Event) {
+
+	if l == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	for _, s := range l.sinks {
+		if err := s.Write(e); err != nil {
+			slog.Warn("Cannot write audit event", "type", e.Type, "err", err)
+		}
+	}
+}
+
+func (l *Logger) Close() error {
+
+	if l == nil {
+		return nil
+	}
+
+	var first error
+
+	for _, s := range l.sinks {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,145 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// fileSink writes one json per line, rotated by size as audit.log.1, audit.log.2 ...
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	lock sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func intParam(u *url.URL, name string, def int) (int, error) {
+	v := u.Query().Get(name)
+
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("bad %v %q", name, v)
+	}
+
+	return i, nil
+}
+
+func newFileSink(u *url.URL) (*fileSink, error) {
+
+	if u.Path == "" {
+		return nil, fmt.Errorf("no path")
+	}
+
+	maxSize, err := intParam(u, "max_size_mb", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBackups, err := intParam(u, "max_backups", 5)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fileSink{
+		path:       u.Path,
+		maxSize:    int64(maxSize) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.f = f
+	s.size = fi.Size()
+
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return s.open()
+	}
+
+	for i := s.maxBackups - 1; i > 0; i-- {
+		old := fmt.Sprintf("%v.%v", s.path, i)
+
+		if err := os.Rename(old, fmt.Sprintf("%v.%v", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+func (s *fileSink) Write(e *Event) error {
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	b = append(b, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.f.Close()
+}
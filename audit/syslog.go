@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"net/url"
+	"strings"
+)
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// syslog:/// for local syslog, syslog://host:port for udp, syslog+tcp://host:port for tcp
+func newSyslogSink(u *url.URL) (*syslogSink, error) {
+
+	network := ""
+
+	if u.Host != "" {
+		network = "udp"
+
+		if i := strings.Index(u.Scheme, "+"); i > 0 {
+			network = u.Scheme[i+1:]
+		}
+	}
+
+	tag := u.Query().Get("tag")
+
+	if tag == "" {
+		tag = "docker-wicket"
+	}
+
+	w, err := syslog.Dial(network, u.Host, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogSink{w}, nil
+}
+
+func (s *syslogSink) Write(e *Event) error {
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return s.w.Info(string(b))
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
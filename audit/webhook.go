@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const webhookQueue = 1024
+
+// webhookSink POSTs each event as json, in background so a slow collector never slows down login
+// events are dropped when the queue is full
+type webhookSink struct {
+	url    string
+	client *http.Client
+
+	// guards queue against Write after Close
+	lock   sync.Mutex
+	closed bool
+
+	queue chan []byte
+	done  chan struct{}
+}
+
+func newWebhookSink(u *url.URL) (*webhookSink, error) {
+
+	s := &webhookSink{
+		url:    u.String(),
+		client: &http.Client{Timeout: 5 * time.Second},
+		queue:  make(chan []byte, webhookQueue),
+		done:   make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+func (s *webhookSink) run() {
+
+	defer close(s.done)
+
+	for b := range s.queue {
+		if err := s.post(b); err != nil {
+			slog.Warn("Cannot post audit event", "url", s.url, "err", err)
+		}
+	}
+}
+
+func (s *webhookSink) post(b []byte) error {
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%v", resp.Status)
+	}
+
+	return nil
+}
+
+func (s *webhookSink) Write(e *Event) error {
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("webhook closed, event dropped")
+	}
+
+	select {
+	case s.queue <- b:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue full, event dropped")
+	}
+}
+
+// Close sends what is queued, waits at most 10s
+func (s *webhookSink) Close() error {
+	s.lock.Lock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+
+	s.lock.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("audit events not all sent to %v", s.url)
+	}
+}
@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+
+	var lock sync.Mutex
+	var got []Event
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		var e Event
+
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		lock.Lock()
+		got = append(got, e)
+		lock.Unlock()
+	}))
+
+	defer srv.Close()
+
+	l, err := Open([]string{srv.URL + "/audit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := []*Event{
+		{Type: Login, API: "v2", Username: "user1", Identity: "user1", Method: "basic", Result: Success},
+		{Type: Access, API: "v1", Username: "user1", Result: Failure, ScopeType: "repository", ScopeName: "ns/repo", Denied: []string{"write"}},
+		{Type: Token, API: "v2", Identity: "user1", Result: Success, Granted: []string{"pull"}, TokenID: "jti1"},
+	}
+
+	for _, e := range sent {
+		l.Log(e)
+	}
+
+	// Close waits for what is queued
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(got) != len(sent) {
+		t.Fatalf("got %v events, want %v", len(got), len(sent))
+	}
+
+	for i, e := range sent {
+		g := got[i]
+
+		if g.Type != e.Type || g.API != e.API || g.Result != e.Result || g.Username != e.Username || g.ScopeName != e.ScopeName || g.TokenID != e.TokenID {
+			t.Errorf("event %v: got %+v, want %+v", i, g, *e)
+		}
+
+		if g.Time.IsZero() {
+			t.Errorf("event %v has no time", i)
+		}
+	}
+}
+
+func TestWebhookCollectorDown(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+
+	defer srv.Close()
+
+	l, err := Open([]string{srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a broken collector is logged, never a panic or a block
+	l.Log(&Event{Type: Login, Result: Failure})
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookWriteAfterClose(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newWebhookSink(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// requests in flight on reload may still log to a closed logger
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				s.Write(&Event{Type: Login, Result: Success})
+			}
+		}()
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+
+	if err := s.Write(&Event{Type: Login, Result: Success}); err == nil {
+		t.Fatal("write after close accepted")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
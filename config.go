@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/handler"
+	"github.com/tg123/docker-wicket/index"
+)
+
+const configUsage = `Usage: docker-wicket [OPTIONS] config COMMAND
+
+Commands:
+  check    validate --config, flags and env, load token cert and drivers, without serving
+`
+
+func configCommand(args []string) error {
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return fmt.Errorf("config: missing command")
+	}
+
+	switch args[0] {
+	case "check":
+		return configCheck()
+	}
+
+	fmt.Fprint(os.Stderr, configUsage)
+	return fmt.Errorf("config: unknown command %v", args[0])
+}
+
+// the config file itself is validated in parseConf, what is left is what serve would fail on
+func configCheck() error {
+
+	var errs []string
+
+	check := func(what string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", what, err))
+		}
+	}
+
+	check("token cert", newTokenAuth().LoadCertAndKey(certPath, certKeyPath))
+
+	_, err := handler.ParseTrustedProxies(splitList(trustedProxies))
+	check("trusted proxies", err)
+
+	if d, err := loadACL(); err != nil {
+		check(fmt.Sprintf("acl driver %q", aclDriverName), err)
+	} else {
+		check(fmt.Sprintf("acl driver %q", aclDriverName), acl.Check(d))
+		acl.Close(d)
+	}
+
+	if d, err := loadIndex(); err != nil {
+		check(fmt.Sprintf("index driver %q", indexDriverName), err)
+	} else {
+		check(fmt.Sprintf("index driver %q", indexDriverName), index.Check(d))
+		index.Close(d)
+	}
+
+	if tlsCertPath != "" {
+		_, err := tlsConfig()
+		check("tls", err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config check failed:\n%v", strings.Join(errs, "\n"))
+	}
+
+	fmt.Println("config ok")
+
+	return nil
+}
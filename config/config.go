@@ -0,0 +1,638 @@
+// Package config reads a yaml config file into the flags its settings stand for
+//
+//	server:
+//	  port: 9999
+//	token:
+//	  cert: /etc/wicket/token.crt
+//	acl:
+//	  driver: htpasswd
+//	  htpasswd:
+//	    file: /etc/wicket/htpasswd
+//
+// acl.htpasswd.file is --acl_htpasswd_file, so drivers get settings without knowing the file
+//
+// a driver can be used many times as instances named in the file, acl.driver lists those tried in order
+//
+//	acl:
+//	  driver: staff,partners
+//	  instances:
+//	    staff:
+//	      driver: htpasswd
+//	      file: /etc/wicket/staff.htpasswd
+//	    partners:
+//	      driver: htpasswd
+//	      file: /etc/wicket/partners.htpasswd
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/pkg/mflag"
+	"gopkg.in/yaml.v3"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/driver"
+	"github.com/tg123/docker-wicket/index"
+)
+
+// Error is a problem at a position in the config file
+type Error struct {
+	File   string
+	Line   int
+	Column int
+
+	// dotted path of the setting, say, acl.htpasswd.file
+	Path string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v:%v:%v: %v: %v", e.File, e.Line, e.Column, e.Path, e.Msg)
+}
+
+// Errors are all problems found in a config file
+type Errors []*Error
+
+func (es Errors) Error() string {
+	s := make([]string, len(es))
+
+	for i, e := range es {
+		s[i] = e.Error()
+	}
+
+	return strings.Join(s, "\n")
+}
+
+// Repeated is implemented by flag values set once per item, say, --audit_sink,
+// Reset empties it for the file to be applied again
+// a list for other flags is joined by comma
+type Repeated interface {
+	Reset()
+}
+
+// section maps keys to flag names or sub sections
+type section map[string]interface{}
+
+// driverSection also takes settings of its drivers, as keys named after drivers
+type driverSection struct {
+	section
+
+	// flag of the driver name
+	driverFlag string
+	drivers    func() []string
+	options    func(driver string) ([]driver.Option, bool)
+
+	// flag of a driver's option
+	flag func(driver, option string) string
+}
+
+// ACLFlag is the flag of an acl driver option, say, acl_htpasswd_file
+func ACLFlag(driver, option string) string {
+	return "acl_" + driver + "_" + option
+}
+
+// IndexFlag is the flag of an index driver option, say, v1_index_file_path
+// v1file keeps its flags as v1_index_file_*
+func IndexFlag(driver, option string) string {
+	return "v1_index_" + strings.TrimPrefix(driver, "v1") + "_" + option
+}
+
+var schema = section{
+	"server": section{
+		"addr":            "addr",
+		"port":            "port",
+		"drain_timeout":   "drain_timeout",
+		"shutdown_delay":  "shutdown_delay",
+		"trusted_proxies": "trusted_proxies",
+		"log": section{
+			"level":  "log_level",
+			"format": "log_format",
+		},
+		"tls": section{
+			"cert":            "tls_cert",
+			"key":             "tls_key",
+			"min_version":     "tls_min_version",
+			"ciphers":         "tls_ciphers",
+			"client_ca":       "tls_client_ca",
+			"client_username": "tls_client_username",
+		},
+		"tracing": section{
+			"exporter":     "trace_exporter",
+			"endpoint":     "trace_endpoint",
+			"service_name": "trace_service_name",
+		},
+		"audit": section{
+			"sinks": "audit_sink",
+		},
+		"api": section{
+			"admin_users": "admin_users",
+		},
+	},
+	"token": section{
+		"issuer":              "issuer",
+		"service":             "service",
+		"expiration":          "expiration",
+		"cert":                "cert",
+		"key":                 "key",
+		"cert_expiry_warning": "cert_expiry_warning",
+	},
+	"acl": &driverSection{
+		section: section{
+			"driver":     "acl_driver",
+			"robot_file": "robot_file",
+			"token_file": "token_file",
+		},
+		driverFlag: "acl_driver",
+		drivers:    acl.Drivers,
+		options:    acl.Options,
+		flag:       ACLFlag,
+	},
+	"index": &driverSection{
+		section: section{
+			"driver":   "v1_index_driver",
+			"endpoint": "v1_endpoint",
+		},
+		driverFlag: "v1_index_driver",
+		drivers:    index.Drivers,
+		options:    index.Options,
+		flag:       IndexFlag,
+	},
+}
+
+type loader struct {
+	file  string
+	flags map[string]*mflag.Flag
+	skip  func(name string) bool
+	errs  Errors
+
+	// section path -> instances in it
+	result map[string]map[string]*Instance
+}
+
+// Instance is a driver instance named in the config file
+type Instance struct {
+	Driver string
+	Config driver.Config
+}
+
+// File is a config file read in memory, which can be applied again, say, to roll back a reload
+type File struct {
+	name string
+	doc  yaml.Node
+
+	instances map[string]map[string]*Instance
+}
+
+// Instances are driver instances named in section, say, acl, as Apply found them last time
+// nil f has none
+func (f *File) Instances(section string) map[string]*Instance {
+	if f == nil {
+		return nil
+	}
+
+	return f.instances[section]
+}
+
+func Read(filename string) (*File, error) {
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{name: filename}
+
+	if err := yaml.Unmarshal(b, &f.doc); err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+
+	return f, nil
+}
+
+func flags() map[string]*mflag.Flag {
+	m := make(map[string]*mflag.Flag)
+
+	mflag.VisitAll(func(f *mflag.Flag) {
+		for _, n := range f.Names {
+			m[strings.TrimPrefix(n, "-")] = f
+		}
+	})
+
+	return m
+}
+
+// Reset sets flags back to their defaults, but those skip reports
+func Reset(skip func(name string) bool) {
+	mflag.VisitAll(func(f *mflag.Flag) {
+		for _, n := range f.Names {
+			if skip(strings.TrimPrefix(n, "-")) {
+				return
+			}
+		}
+
+		if r, ok := f.Value.(Repeated); ok {
+			r.Reset()
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+	})
+}
+
+// Apply sets flags from settings in the file
+// flags skip reports are left as is, for command line and env to win over the file
+func (f *File) Apply(skip func(name string) bool) error {
+
+	doc := &f.doc
+
+	l := &loader{
+		file:   f.name,
+		flags:  flags(),
+		skip:   skip,
+		result: make(map[string]map[string]*Instance),
+	}
+
+	f.instances = l.result
+
+	// empty file
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	l.section(doc.Content[0], "", schema)
+
+	if len(l.errs) > 0 {
+		return l.errs
+	}
+
+	return nil
+}
+
+func (l *loader) errorf(n *yaml.Node, path string, format string, args ...interface{}) {
+	l.errs = append(l.errs, &Error{
+		File:   l.file,
+		Line:   n.Line,
+		Column: n.Column,
+		Path:   path,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+
+	return n
+}
+
+func kindName(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a map"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+
+	return "a value"
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func keys(s section) string {
+	ks := make([]string, 0, len(s))
+
+	for k := range s {
+		ks = append(ks, k)
+	}
+
+	sort.Strings(ks)
+
+	return strings.Join(ks, ", ")
+}
+
+// mapping calls fn with each key and value of n, a map
+func (l *loader) mapping(n *yaml.Node, path string, fn func(k, v *yaml.Node)) {
+	n = resolve(n)
+
+	if n.Kind != yaml.MappingNode {
+		l.errorf(n, path, "want a map of settings, got %v", kindName(n))
+		return
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		fn(n.Content[i], resolve(n.Content[i+1]))
+	}
+}
+
+func (l *loader) section(n *yaml.Node, path string, s section) {
+	l.mapping(n, path, func(k, v *yaml.Node) {
+		p := join(path, k.Value)
+
+		switch t := s[k.Value].(type) {
+		case string:
+			l.set(v, p, t)
+		case section:
+			l.section(v, p, t)
+		case *driverSection:
+			l.driverSection(v, p, t)
+		default:
+			l.errorf(k, p, "unknown setting, want one of %v", keys(s))
+		}
+	})
+}
+
+func (l *loader) driverSection(n *yaml.Node, path string, s *driverSection) {
+
+	n = resolve(n)
+
+	if n.Kind != yaml.MappingNode {
+		l.errorf(n, path, "want a map of settings, got %v", kindName(n))
+		return
+	}
+
+	drivers := s.drivers()
+
+	registered := func(name string) bool {
+		i := sort.SearchStrings(drivers, name)
+		return i < len(drivers) && drivers[i] == name
+	}
+
+	instances := make(map[string]*Instance)
+
+	l.mapping(n, path, func(k, v *yaml.Node) {
+		if k.Value == "instances" {
+			l.instances(v, join(path, k.Value), s, registered, instances)
+		}
+	})
+
+	l.result[path] = instances
+
+	known := func(name string) bool {
+		_, ok := instances[name]
+		return ok || registered(name)
+	}
+
+	// drivers in file, or from command line and env, names of instances or drivers
+	chain := splitList(l.flags[s.driverFlag].Value.String())
+
+	l.mapping(n, path, func(k, v *yaml.Node) {
+		if k.Value != "driver" {
+			return
+		}
+
+		var names []string
+
+		switch v.Kind {
+		case yaml.ScalarNode:
+			names = splitList(v.Value)
+		case yaml.SequenceNode:
+			for _, c := range v.Content {
+				names = append(names, resolve(c).Value)
+			}
+		}
+
+		for _, name := range names {
+			if !known(name) {
+				l.errorf(v, join(path, k.Value), "unknown driver %q, want one of %v or an instance", name, strings.Join(drivers, ", "))
+				return
+			}
+		}
+
+		if !l.skip(s.driverFlag) {
+			chain = names
+		}
+
+		l.set(v, join(path, k.Value), s.driverFlag)
+	})
+
+	l.mapping(n, path, func(k, v *yaml.Node) {
+		p := join(path, k.Value)
+
+		if k.Value == "driver" || k.Value == "instances" {
+			return
+		}
+
+		if f, ok := s.section[k.Value]; ok {
+			l.set(v, p, f.(string))
+			return
+		}
+
+		if !registered(k.Value) {
+			l.errorf(k, p, "unknown setting or driver, want one of %v, instances or %v", keys(s.section), strings.Join(drivers, ", "))
+			return
+		}
+
+		if !contains(chain, k.Value) {
+			l.errorf(k, p, "settings of driver %v, but %v is %q", k.Value, join(path, "driver"), strings.Join(chain, ","))
+			return
+		}
+
+		l.driverSettings(v, p, s, k.Value)
+	})
+}
+
+// instances are drivers named by the user, say, two htpasswd files, each with a driver and its settings
+//
+//	instances:
+//	  staff:
+//	    driver: htpasswd
+//	    file: /etc/wicket/staff.htpasswd
+func (l *loader) instances(n *yaml.Node, path string, s *driverSection, registered func(string) bool, instances map[string]*Instance) {
+	l.mapping(n, path, func(k, v *yaml.Node) {
+		p := join(path, k.Value)
+
+		if registered(k.Value) {
+			l.errorf(k, p, "instance named after a driver, use %v for its settings", k.Value)
+			return
+		}
+
+		if k.Value == "" || strings.ContainsAny(k.Value, ", ") {
+			l.errorf(k, p, "bad instance name %q", k.Value)
+			return
+		}
+
+		in := &Instance{Config: make(driver.Config)}
+
+		l.mapping(v, p, func(ok, ov *yaml.Node) {
+			if ok.Value == "driver" {
+				in.Driver = ov.Value
+			}
+		})
+
+		if !registered(in.Driver) {
+			l.errorf(v, join(p, "driver"), "unknown driver %q, want one of %v", in.Driver, strings.Join(s.drivers(), ", "))
+			return
+		}
+
+		options, _ := s.options(in.Driver)
+
+		known := make(map[string]driver.Option)
+
+		for _, o := range options {
+			known[o.Name] = o
+		}
+
+		l.mapping(v, p, func(ok, ov *yaml.Node) {
+			op := join(p, ok.Value)
+
+			if ok.Value == "driver" {
+				return
+			}
+
+			o, found := known[ok.Value]
+
+			if !found {
+				l.errorf(ok, op, "unknown setting of driver %v, want driver or one of %v", in.Driver, keys(optionSection(options)))
+				return
+			}
+
+			items, good := l.values(ov, op)
+
+			if !good {
+				return
+			}
+
+			if !o.Repeated {
+				items = []string{strings.Join(items, ",")}
+			}
+
+			in.Config[o.Name] = items
+		})
+
+		instances[k.Value] = in
+	})
+}
+
+func optionSection(options []driver.Option) section {
+	s := make(section)
+
+	for _, o := range options {
+		s[o.Name] = o.Name
+	}
+
+	return s
+}
+
+func (l *loader) driverSettings(n *yaml.Node, path string, s *driverSection, name string) {
+
+	known := make(section)
+
+	options, _ := s.options(name)
+
+	for _, o := range options {
+		known[o.Name] = s.flag(name, o.Name)
+	}
+
+	l.mapping(n, path, func(k, v *yaml.Node) {
+		p := join(path, k.Value)
+
+		f, ok := known[k.Value]
+
+		if !ok {
+			if len(known) == 0 {
+				l.errorf(k, p, "unknown setting, the driver has no settings")
+			} else {
+				l.errorf(k, p, "unknown setting, want one of %v", keys(known))
+			}
+
+			return
+		}
+
+		l.set(v, p, f.(string))
+	})
+}
+
+// values of n, a value or a list of values, false if n is neither
+func (l *loader) values(n *yaml.Node, path string) ([]string, bool) {
+
+	var items []string
+
+	switch n.Kind {
+	case yaml.ScalarNode:
+		// key without value
+		if n.Tag == "!!null" {
+			return nil, true
+		}
+
+		items = []string{n.Value}
+	case yaml.SequenceNode:
+		for _, c := range n.Content {
+			c = resolve(c)
+
+			if c.Kind != yaml.ScalarNode {
+				l.errorf(c, path, "want a list of values, got %v in it", kindName(c))
+				return nil, false
+			}
+
+			items = append(items, c.Value)
+		}
+	default:
+		l.errorf(n, path, "want a value or a list, got %v", kindName(n))
+		return nil, false
+	}
+
+	return items, true
+}
+
+// set sets flag name with n, a value or a list of values
+func (l *loader) set(n *yaml.Node, path, name string) {
+
+	items, ok := l.values(n, path)
+
+	if !ok || len(items) == 0 {
+		return
+	}
+
+	f, ok := l.flags[name]
+
+	if !ok {
+		l.errorf(n, path, "flag --%v not found", name)
+		return
+	}
+
+	if l.skip(name) {
+		return
+	}
+
+	if _, ok := f.Value.(Repeated); !ok {
+		items = []string{strings.Join(items, ",")}
+	}
+
+	for _, item := range items {
+		if err := f.Value.Set(item); err != nil {
+			l.errorf(n, path, "invalid value %q: %v", item, err)
+			return
+		}
+	}
+}
+
+func splitList(s string) []string {
+	l := make([]string, 0)
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+
+	return l
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/mflag"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/driver"
+)
+
+var aclDriver = mflag.String([]string{"-acl_driver"}, "", "")
+
+func init() {
+	acl.Register("pwfile", []driver.Option{
+		{Name: "file"},
+		{Name: "realm", Repeated: true},
+	}, func(c driver.Config) (acl.Driver, error) {
+		return nil, nil
+	})
+
+	mflag.String([]string{"-acl_pwfile_file"}, "", "")
+	mflag.String([]string{"-v1_index_driver"}, "", "")
+}
+
+func apply(t *testing.T, yml string) (*File, error) {
+
+	p := filepath.Join(t.TempDir(), "wicket.yml")
+
+	if err := os.WriteFile(p, []byte(yml), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Read(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Reset(func(string) bool { return false })
+
+	return f, f.Apply(func(string) bool { return false })
+}
+
+func TestInstances(t *testing.T) {
+
+	f, err := apply(t, `
+acl:
+  driver: [staff, partners, pwfile]
+  pwfile:
+    file: /etc/wicket/default
+  instances:
+    staff:
+      driver: pwfile
+      file: /etc/wicket/staff
+      realm: [a, b]
+    partners:
+      driver: pwfile
+      file: /etc/wicket/partners
+`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *aclDriver != "staff,partners,pwfile" {
+		t.Fatalf("acl_driver is %q", *aclDriver)
+	}
+
+	instances := f.Instances("acl")
+
+	if len(instances) != 2 {
+		t.Fatalf("got %v instances, want 2", len(instances))
+	}
+
+	staff := instances["staff"]
+
+	if staff.Driver != "pwfile" || staff.Config.String("file") != "/etc/wicket/staff" || len(staff.Config.Strings("realm")) != 2 {
+		t.Fatalf("staff is %+v", staff)
+	}
+
+	if instances["partners"].Config.String("file") != "/etc/wicket/partners" {
+		t.Fatalf("partners is %+v", instances["partners"])
+	}
+}
+
+func TestInstanceErrors(t *testing.T) {
+
+	for _, c := range []struct {
+		yml  string
+		want string
+	}{
+		{`
+acl:
+  driver: nobody
+`, `unknown driver "nobody"`},
+		{`
+acl:
+  driver: staff
+  instances:
+    staff:
+      driver: nothing
+`, `unknown driver "nothing"`},
+		{`
+acl:
+  driver: staff
+  instances:
+    staff:
+      driver: pwfile
+      nope: 1
+`, "unknown setting of driver pwfile"},
+		{`
+acl:
+  driver: pwfile
+  instances:
+    pwfile:
+      driver: pwfile
+`, "instance named after a driver"},
+		{`
+acl:
+  driver: staff
+  instances:
+    staff:
+      driver: pwfile
+  pwfile:
+    file: /etc/wicket/default
+`, "settings of driver pwfile"},
+	} {
+		_, err := apply(t, c.yml)
+
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: got %v, want %q", c.yml, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package credential has what robots and personal access tokens share, secrets kept as hashes and a json file store
+package credential
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// Secret is the hash of a secret only its holder knows, embedded in robots and tokens
+type Secret struct {
+	SecretHash string `json:"secret_hash,omitempty"`
+	Created    int64  `json:"created"`
+	Expires    int64  `json:"expires,omitempty"` // unix time, 0 means never
+}
+
+// random returns n random bytes, url safe encoded
+func random(n int) (string, error) {
+	b := make([]byte, n)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewSecret creates a secret expiring in ttl, 0 means never, returns it and the Secret keeping its hash
+func NewSecret(ttl time.Duration) (Secret, string, error) {
+
+	secret, err := random(24)
+	if err != nil {
+		return Secret{}, "", err
+	}
+
+	now := time.Now()
+
+	s := Secret{
+		SecretHash: hash(secret),
+		Created:    now.Unix(),
+	}
+
+	if ttl > 0 {
+		s.Expires = now.Add(ttl).Unix()
+	}
+
+	return s, secret, nil
+}
+
+// secrets are random, a fast hash is enough
+func hash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func (s *Secret) Expired() bool {
+	return s.Expires != 0 && time.Now().Unix() >= s.Expires
+}
+
+func (s *Secret) Match(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(s.SecretHash), []byte(hash(secret))) == 1
+}
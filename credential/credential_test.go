@@ -0,0 +1,92 @@
+package credential
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecret(t *testing.T) {
+
+	s, secret, err := NewSecret(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Match(secret) || s.Match(secret+"x") || s.Match("") {
+		t.Fatal("secret matched wrong")
+	}
+
+	if strings.Contains(s.SecretHash, secret) || s.Expired() {
+		t.Fatalf("bad secret %+v", s)
+	}
+
+	s, _, err = NewSecret(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Expired() {
+		t.Fatal("expired in an hour")
+	}
+
+	s.Expires = time.Now().Add(-time.Second).Unix()
+
+	if !s.Expired() {
+		t.Fatal("not expired")
+	}
+}
+
+type thing struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+
+	Secret
+}
+
+func TestFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "things.json")
+
+	f := NewFile[thing](path, "thing")
+
+	if v, err := f.Get("a"); v != nil || err != nil {
+		t.Fatalf("got %v, %v from no file", v, err)
+	}
+
+	if err := f.Create("a", &thing{Name: "a", Secret: Secret{SecretHash: "h"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Create("a", &thing{Name: "a"}); err == nil || !strings.Contains(err.Error(), "thing a already exists") {
+		t.Fatalf("created a twice: %v", err)
+	}
+
+	if err := f.Update("a", func(v *thing) bool { v.Count++; return true }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Update("a", func(v *thing) bool { v.Count++; return false }); err != nil {
+		t.Fatal(err)
+	}
+
+	// another process sees it
+	v, err := NewFile[thing](path, "thing").Get("a")
+
+	if err != nil || v == nil || v.Count != 1 || v.SecretHash != "h" {
+		t.Fatalf("got %+v, %v", v, err)
+	}
+
+	if err := f.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Delete("a"); err == nil || !strings.Contains(err.Error(), "thing a not found") {
+		t.Fatalf("deleted a twice: %v", err)
+	}
+
+	if err := f.Update("a", func(v *thing) bool { return true }); err == nil {
+		t.Fatal("updated a deleted")
+	}
+}
@@ -0,0 +1,138 @@
+package credential
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/tg123/docker-wicket/atomicfile"
+)
+
+// File keeps all of T by key in one json file, named what in errors, say, robot
+// the file is read every time, so changes made by another process, say, `docker-wicket robot`, take effect without restart
+type File[T any] struct {
+	Path string
+
+	what string
+	lock sync.Mutex
+}
+
+func NewFile[T any](path, what string) *File[T] {
+	return &File[T]{Path: path, what: what}
+}
+
+func (f *File[T]) load() (map[string]*T, error) {
+
+	m := make(map[string]*T)
+
+	b, err := ioutil.ReadFile(f.Path)
+
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("bad %v file %v: %v", f.what, f.Path, err)
+	}
+
+	return m, nil
+}
+
+func (f *File[T]) save(m map[string]*T) error {
+
+	b, err := json.MarshalIndent(m, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(f.Path, b, 0600)
+}
+
+// All returns everything by key
+func (f *File[T]) All() (map[string]*T, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.load()
+}
+
+// Get returns nil if key is not there
+func (f *File[T]) Get(key string) (*T, error) {
+
+	m, err := f.All()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m[key], nil
+}
+
+func (f *File[T]) Create(key string, v *T) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	m, err := f.load()
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := m[key]; ok {
+		return fmt.Errorf("%v %v already exists", f.what, key)
+	}
+
+	m[key] = v
+
+	return f.save(m)
+}
+
+func (f *File[T]) Delete(key string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	m, err := f.load()
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := m[key]; !ok {
+		return fmt.Errorf("%v %v not found", f.what, key)
+	}
+
+	delete(m, key)
+
+	return f.save(m)
+}
+
+// Update saves what update changes of key, nothing is saved if update returns false
+func (f *File[T]) Update(key string, update func(v *T) bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	m, err := f.load()
+
+	if err != nil {
+		return err
+	}
+
+	v, ok := m[key]
+
+	if !ok {
+		return fmt.Errorf("%v %v not found", f.what, key)
+	}
+
+	if !update(v) {
+		return nil
+	}
+
+	return f.save(m)
+}
@@ -0,0 +1,151 @@
+// Package driver is the registry of acl and index driver factories
+// a factory gets the settings of one instance as a Config, so that a driver can be used many times with different settings
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option is a setting a driver takes, say, file of htpasswd
+type Option struct {
+	Name    string
+	Usage   string
+	Default string
+
+	// Repeated takes many values, say, trusted issuers of oidc
+	Repeated bool
+}
+
+// Config is settings of a driver instance, option name to its values
+type Config map[string][]string
+
+// String is the last value of name, empty if not set
+func (c Config) String(name string) string {
+	v := c[name]
+
+	if len(v) == 0 {
+		return ""
+	}
+
+	return v[len(v)-1]
+}
+
+// Strings is all values of a Repeated option
+func (c Config) Strings(name string) []string {
+	return c[name]
+}
+
+// List splits a comma separated value, empty items are dropped
+func (c Config) List(name string) []string {
+	l := make([]string, 0)
+
+	for _, v := range strings.Split(c.String(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+
+	return l
+}
+
+func (c Config) Int64(name string) (int64, error) {
+	i, err := strconv.ParseInt(c.String(name), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad %v: %v", name, err)
+	}
+
+	return i, nil
+}
+
+func (c Config) Duration(name string) (time.Duration, error) {
+	d, err := time.ParseDuration(c.String(name))
+	if err != nil {
+		return 0, fmt.Errorf("bad %v: %v", name, err)
+	}
+
+	return d, nil
+}
+
+func (c Config) Bool(name string) (bool, error) {
+	b, err := strconv.ParseBool(c.String(name))
+	if err != nil {
+		return false, fmt.Errorf("bad %v: %v", name, err)
+	}
+
+	return b, nil
+}
+
+type factory[D any] struct {
+	options []Option
+	new     func(c Config) (D, error)
+}
+
+// Registry maps driver names to factories of D, say, acl.Driver
+type Registry[D any] struct {
+	factories map[string]factory[D]
+}
+
+func (r *Registry[D]) Register(name string, options []Option, new func(c Config) (D, error)) {
+	if r.factories == nil {
+		r.factories = make(map[string]factory[D])
+	}
+
+	r.factories[name] = factory[D]{options, new}
+}
+
+// New creates an instance of driver name, options not in c are their defaults
+func (r *Registry[D]) New(name string, c Config) (D, error) {
+
+	var d D
+
+	f, ok := r.factories[name]
+
+	if !ok {
+		return d, fmt.Errorf("Driver not found")
+	}
+
+	known := make(map[string]bool)
+	full := make(Config)
+
+	for _, o := range f.options {
+		known[o.Name] = true
+
+		if v, ok := c[o.Name]; ok {
+			full[o.Name] = v
+		} else if o.Default != "" {
+			full[o.Name] = []string{o.Default}
+		}
+	}
+
+	for n := range c {
+		if !known[n] {
+			return d, fmt.Errorf("unknown option %q of driver %v", n, name)
+		}
+	}
+
+	return f.new(full)
+}
+
+// Names lists registered drivers
+func (r *Registry[D]) Names() []string {
+	names := make([]string, 0, len(r.factories))
+
+	for n := range r.factories {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Options of driver name, false if not registered
+func (r *Registry[D]) Options(name string) ([]Option, bool) {
+	f, ok := r.factories[name]
+
+	return f.options, ok
+}
@@ -0,0 +1,156 @@
+package main
+
+// flags of driver options, say, --acl_htpasswd_file, and `drivers` listing them
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/docker/pkg/mflag"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/config"
+	"github.com/tg123/docker-wicket/driver"
+	"github.com/tg123/docker-wicket/index"
+)
+
+// driverFlags are flags of all options of a kind of drivers, driver -> option -> values
+type driverFlags struct {
+	flag    func(driver, option string) string
+	options func(driver string) ([]driver.Option, bool)
+
+	values map[string]map[string]func() []string
+}
+
+var (
+	aclDriverFlags   = &driverFlags{flag: config.ACLFlag, options: acl.Options}
+	indexDriverFlags = &driverFlags{flag: config.IndexFlag, options: index.Options}
+)
+
+func (f *driverFlags) register(drivers []string) {
+
+	f.values = make(map[string]map[string]func() []string)
+
+	for _, d := range drivers {
+		options, _ := f.options(d)
+
+		values := make(map[string]func() []string)
+
+		for _, o := range options {
+			names := []string{"-" + f.flag(d, o.Name)}
+
+			if o.Repeated {
+				l := &stringList{}
+				mflag.Var(l, names, o.Usage)
+				values[o.Name] = func() []string { return *l }
+			} else {
+				s := new(string)
+				mflag.StringVar(s, names, o.Default, o.Usage)
+				values[o.Name] = func() []string { return []string{*s} }
+			}
+		}
+
+		f.values[d] = values
+	}
+}
+
+// config of driver d from its flags
+func (f *driverFlags) config(d string) driver.Config {
+
+	c := make(driver.Config)
+
+	for name, get := range f.values[d] {
+		if v := get(); len(v) > 0 {
+			c[name] = v
+		}
+	}
+
+	return c
+}
+
+// loadInstance loads name, an instance in the config file section, or a driver with settings from its flags
+func loadInstance[D any](name, section string, f *driverFlags, load func(string, driver.Config) (D, error)) (D, error) {
+
+	if in, ok := loadedConfig.Instances(section)[name]; ok {
+		return load(in.Driver, in.Config)
+	}
+
+	return load(name, f.config(name))
+}
+
+// loadACL loads instances in --acl_driver, a chain if more than one
+func loadACL() (acl.Driver, error) {
+
+	names := splitList(aclDriverName)
+
+	if len(names) == 1 {
+		return loadInstance(names[0], "acl", aclDriverFlags, acl.Load)
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("--acl_driver not set")
+	}
+
+	chain := make(acl.Chain, 0, len(names))
+
+	for _, name := range names {
+		d, err := loadInstance(name, "acl", aclDriverFlags, acl.Load)
+		if err != nil {
+			chain.Close()
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+
+		chain = append(chain, d)
+	}
+
+	return chain, nil
+}
+
+// loadIndex loads the instance in --v1_index_driver, there is no chain of index drivers
+func loadIndex() (index.Driver, error) {
+
+	if strings.Contains(indexDriverName, ",") {
+		return nil, fmt.Errorf("only one index driver can be used")
+	}
+
+	return loadInstance(strings.TrimSpace(indexDriverName), "index", indexDriverFlags, index.Load)
+}
+
+func registerDriverFlags() {
+	aclDriverFlags.register(acl.Drivers())
+	indexDriverFlags.register(index.Drivers())
+}
+
+func driversCommand(args []string) error {
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	list := func(kind string, drivers []string, f *driverFlags) {
+		fmt.Fprintf(w, "%v drivers:\n", kind)
+
+		for _, d := range drivers {
+			fmt.Fprintf(w, "  %v\n", d)
+
+			options, _ := f.options(d)
+
+			for _, o := range options {
+				def := o.Default
+
+				if o.Repeated {
+					def = "(repeated)"
+				}
+
+				fmt.Fprintf(w, "    --%v\t%v\t%v\n", f.flag(d, o.Name), def, o.Usage)
+			}
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	list("ACL", acl.Drivers(), aclDriverFlags)
+	list("Index", index.Drivers(), indexDriverFlags)
+
+	return w.Flush()
+}
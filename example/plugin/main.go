@@ -0,0 +1,65 @@
+// an acl plugin: users in $PLUGIN_USERS can login, anyone can pull, and users push to the namespace of own username
+//
+//	PLUGIN_USERS=user1:secret1,user2:secret2 docker-wicket --acl_driver=plugin --acl_plugin_command=/path/to/plugin
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/plugin"
+)
+
+type driver struct {
+	users map[acl.Username]string
+}
+
+// parseUsers parses comma separated username:password
+func parseUsers(s string) map[acl.Username]string {
+
+	users := make(map[acl.Username]string)
+
+	for _, u := range strings.Split(s, ",") {
+		username, password, ok := strings.Cut(strings.TrimSpace(u), ":")
+
+		if !ok || username == "" || password == "" {
+			continue
+		}
+
+		users[acl.Username(username)] = password
+	}
+
+	return users
+}
+
+func (d *driver) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	p, ok := d.users[username]
+	return ok && subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1, nil
+}
+
+func (d *driver) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	if perm == acl.READ {
+		return true, nil
+	}
+
+	_, ok := d.users[username]
+
+	return ok && string(username) == namespace, nil
+}
+
+func main() {
+	// wicket passes its env to the plugin
+	users := parseUsers(os.Getenv("PLUGIN_USERS"))
+
+	if len(users) == 0 {
+		log.Fatal("no users in PLUGIN_USERS, want user1:secret1,user2:secret2")
+	}
+
+	// stdout is the protocol, log goes to stderr
+	if err := plugin.ServeStdio(&driver{users}, nil); err != nil {
+		log.Fatal(err)
+	}
+}
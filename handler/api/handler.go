@@ -0,0 +1,516 @@
+package api
+
+// management api, authenticated with the acl driver via Basic auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocraft/web"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/tg123/docker-wicket/handler"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/pat"
+	"github.com/tg123/docker-wicket/robot"
+	"github.com/tg123/docker-wicket/tracing"
+)
+
+type RunningContext struct {
+	handler.RunningContext
+
+	// usernames allowed to manage robots and backup the index
+	Admins []string
+
+	// nil if robot accounts are disabled
+	Robots robot.Store
+
+	// nil if personal access tokens are disabled
+	Tokens pat.Store
+
+	// nil if there is no index
+	Index index.Driver
+}
+
+type context struct {
+	*handler.ShareWebContext
+
+	username acl.Username
+
+	// username alone is granted what the session is, see acl.ByUsername
+	byUsername bool
+
+	rc *RunningContext
+}
+
+// Handler serves requests with its RunningContext, which can be swapped by Update
+type Handler struct {
+	rc atomic.Pointer[RunningContext]
+}
+
+func writeJSON(rw web.ResponseWriter, status int, v interface{}) {
+
+	b, err := json.Marshal(v)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(b)
+}
+
+func (c *context) authUser(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	cred := c.rc.Credential(req.Request)
+
+	if cred.Username == acl.Anonymous {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="docker-wicket"`)
+		http.Error(rw, "", http.StatusUnauthorized)
+		return
+	}
+
+	_, span := tracing.Start(req.Context(), "acl.Login", attribute.String("wicket.username", string(cred.Username)))
+
+	session, err := acl.Login(c.rc.Acl, cred)
+
+	span.SetAttributes(attribute.Bool("wicket.acl.ok", session != nil))
+	tracing.End(span, err)
+
+	c.rc.AuditLogin(req.Request, "api", cred, session, err)
+
+	if err != nil {
+		c.Logger().Error("acl login failed", "username", cred.Username, "err", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if session == nil {
+		http.Error(rw, "", http.StatusForbidden)
+		return
+	}
+
+	c.username = session.Username()
+	c.byUsername = acl.ByUsername(session)
+
+	next(rw, req)
+}
+
+// robots and tokens can be turned on or off by reload, so their routes are always there
+
+func (c *context) robotsEnabled(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	if c.rc.Robots == nil {
+		http.Error(rw, "robots are disabled", http.StatusNotFound)
+		return
+	}
+
+	next(rw, req)
+}
+
+func (c *context) tokensEnabled(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	if c.rc.Tokens == nil {
+		http.Error(rw, "personal access tokens are disabled", http.StatusNotFound)
+		return
+	}
+
+	next(rw, req)
+}
+
+// managing robots and tokens needs the real password, and robots manage nothing
+func (c *context) authPrimary(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	_, password, _ := req.BasicAuth()
+
+	if _, _, ok := pat.Parse(acl.Password(password)); ok {
+		http.Error(rw, "personal access token cannot manage robots or tokens", http.StatusForbidden)
+		return
+	}
+
+	if _, ok := robot.NameOf(c.username); ok {
+		http.Error(rw, "robot cannot manage robots or tokens", http.StatusForbidden)
+		return
+	}
+
+	next(rw, req)
+}
+
+// admins are named by username, an identity logged in by its credential, say, an OIDC subject, is not one
+func (c *context) authAdmin(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+
+	if !c.byUsername {
+		http.Error(rw, "", http.StatusForbidden)
+		return
+	}
+
+	for _, a := range c.rc.Admins {
+		if acl.Username(a) == c.username {
+			next(rw, req)
+			return
+		}
+	}
+
+	http.Error(rw, "", http.StatusForbidden)
+}
+
+// robots
+
+type robotRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Grants      []string `json:"grants"`
+	ExpiresIn   int64    `json:"expires_in"` // sec, 0 means never
+}
+
+type robotResponse struct {
+	*robot.Robot
+
+	Username acl.Username `json:"username"`
+	Secret   string       `json:"secret,omitempty"`
+}
+
+func newRobotResponse(r *robot.Robot, secret string) *robotResponse {
+	// never give out the hash
+	v := *r
+	v.SecretHash = ""
+
+	return &robotResponse{
+		Robot:    &v,
+		Username: r.Username(),
+		Secret:   secret,
+	}
+}
+
+func (c *context) listRobots(rw web.ResponseWriter, req *web.Request) {
+
+	_, span := tracing.Start(req.Context(), "robot.List")
+
+	l, err := c.rc.Robots.List()
+
+	tracing.End(span, err)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]*robotResponse, len(l))
+
+	for i, r := range l {
+		result[i] = newRobotResponse(r, "")
+	}
+
+	writeJSON(rw, http.StatusOK, result)
+}
+
+func (c *context) createRobot(rw web.ResponseWriter, req *web.Request) {
+
+	var rr robotRequest
+
+	defer req.Body.Close()
+
+	if err := json.NewDecoder(req.Body).Decode(&rr); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var grants acl.Grants
+
+	for _, g := range rr.Grants {
+		if err := grants.Set(g); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	r, secret, err := robot.New(rr.Name, grants, time.Duration(rr.ExpiresIn)*time.Second)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.Description = rr.Description
+
+	_, span := tracing.Start(req.Context(), "robot.Create", attribute.String("wicket.robot", r.Name))
+
+	err = c.rc.Robots.Create(r)
+
+	tracing.End(span, err)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+
+	writeJSON(rw, http.StatusCreated, newRobotResponse(r, secret))
+}
+
+func (c *context) deleteRobot(rw web.ResponseWriter, req *web.Request) {
+
+	name := req.PathParams["name"]
+
+	_, span := tracing.Start(req.Context(), "robot.Get", attribute.String("wicket.robot", name))
+
+	r, err := c.rc.Robots.Get(name)
+
+	tracing.End(span, err)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r == nil {
+		http.Error(rw, "", http.StatusNotFound)
+		return
+	}
+
+	_, span = tracing.Start(req.Context(), "robot.Delete", attribute.String("wicket.robot", name))
+
+	err = c.rc.Robots.Delete(name)
+
+	tracing.End(span, err)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Error(rw, "", http.StatusNoContent)
+}
+
+// personal access tokens
+
+type tokenRequest struct {
+	Description string   `json:"description"`
+	Grants      []string `json:"grants"`
+	ExpiresIn   int64    `json:"expires_in"` // sec, 0 means never
+}
+
+type tokenResponse struct {
+	*pat.Token
+
+	// used as password in docker login
+	Password string `json:"password,omitempty"`
+}
+
+func newTokenResponse(t *pat.Token, password string) *tokenResponse {
+	// never give out the hash
+	v := *t
+	v.SecretHash = ""
+
+	return &tokenResponse{
+		Token:    &v,
+		Password: password,
+	}
+}
+
+func (c *context) listTokens(rw web.ResponseWriter, req *web.Request) {
+
+	_, span := tracing.Start(req.Context(), "pat.List")
+
+	l, err := c.rc.Tokens.List(c.username)
+
+	tracing.End(span, err)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]*tokenResponse, len(l))
+
+	for i, t := range l {
+		result[i] = newTokenResponse(t, "")
+	}
+
+	writeJSON(rw, http.StatusOK, result)
+}
+
+func (c *context) createToken(rw web.ResponseWriter, req *web.Request) {
+
+	// a token can do what its owner can, looked up by username, which grants nothing to, say, an OIDC identity
+	if !c.byUsername {
+		http.Error(rw, "identity logged in by its credential cannot have tokens", http.StatusForbidden)
+		return
+	}
+
+	var tr tokenRequest
+
+	defer req.Body.Close()
+
+	if err := json.NewDecoder(req.Body).Decode(&tr); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var grants acl.Grants
+
+	for _, g := range tr.Grants {
+		if err := grants.Set(g); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	t, password, err := pat.New(c.username, grants, time.Duration(tr.ExpiresIn)*time.Second)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t.Description = tr.Description
+
+	_, span := tracing.Start(req.Context(), "pat.Create", attribute.String("wicket.token.id", t.ID))
+
+	err = c.rc.Tokens.Create(t)
+
+	tracing.End(span, err)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(rw, http.StatusCreated, newTokenResponse(t, password))
+}
+
+func (c *context) deleteToken(rw web.ResponseWriter, req *web.Request) {
+
+	id := req.PathParams["id"]
+
+	_, span := tracing.Start(req.Context(), "pat.Get", attribute.String("wicket.token.id", id))
+
+	t, err := c.rc.Tokens.Get(id)
+
+	tracing.End(span, err)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// others' tokens are treated as not existing
+	if t == nil || t.Owner != c.username {
+		http.Error(rw, "", http.StatusNotFound)
+		return
+	}
+
+	_, span = tracing.Start(req.Context(), "pat.Delete", attribute.String("wicket.token.id", id))
+
+	err = c.rc.Tokens.Delete(id)
+
+	tracing.End(span, err)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Error(rw, "", http.StatusNoContent)
+}
+
+// index
+
+// backupIndex sends a consistent copy of the index storage, copied to a temp file first,
+// so that a slow download does not hold up pushes, say, bolt blocks writes while copying
+func (c *context) backupIndex(rw web.ResponseWriter, req *web.Request) {
+
+	if c.rc.Index == nil {
+		http.Error(rw, "no index", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.CreateTemp("", "wicket-index-backup-")
+	if err != nil {
+		c.Logger().Error("cannot create backup file", "err", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, span := tracing.Start(req.Context(), "index.Backup")
+
+	err = index.Backup(c.rc.Index, f)
+
+	tracing.End(span, err)
+
+	if err == index.ErrCannotBackup {
+		http.Error(rw, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	if err != nil {
+		c.Logger().Error("index backup failed", "err", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.Logger().Info("index backup", "username", c.username)
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Disposition", `attachment; filename="index.backup"`)
+
+	http.ServeContent(rw, req.Request, "", time.Time{}, f)
+}
+
+// requests in flight keep the RunningContext they started with, its drivers bound to the request, say, to log its id
+func (h *Handler) loadRunningContext(c *context, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	rc := *h.rc.Load()
+	rc.RunningContext = rc.RunningContext.WithContext(req.Context())
+
+	if rc.Index != nil {
+		rc.Index = index.WithContext(rc.Index, req.Context())
+	}
+
+	c.rc = &rc
+
+	next(rw, req)
+}
+
+// Update swaps rc in for new requests, say, on reload
+func (h *Handler) Update(rc *RunningContext) {
+	h.rc.Store(rc)
+}
+
+func InstallHandler(rootRouter *web.Router, rc *RunningContext) *Handler {
+
+	h := &Handler{}
+	h.rc.Store(rc)
+
+	c := context{}
+
+	api := rootRouter.Subrouter(c, "/api").
+		Middleware(h.loadRunningContext).
+		Middleware((*context).authUser)
+
+	api.Subrouter(c, "/robots").
+		Middleware((*context).robotsEnabled).
+		Middleware((*context).authPrimary).
+		Middleware((*context).authAdmin).
+		Get("/", (*context).listRobots).
+		Post("/", (*context).createRobot).
+		Delete("/:name", (*context).deleteRobot)
+
+	api.Subrouter(c, "/tokens").
+		Middleware((*context).tokensEnabled).
+		Middleware((*context).authPrimary).
+		Get("/", (*context).listTokens).
+		Post("/", (*context).createToken).
+		Delete("/:id", (*context).deleteToken)
+
+	api.Subrouter(c, "/index").
+		Middleware((*context).authPrimary).
+		Middleware((*context).authAdmin).
+		Get("/backup", (*context).backupIndex)
+
+	return h
+}
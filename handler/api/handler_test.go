@@ -0,0 +1,282 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/handler"
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/index/bolt"
+	"github.com/tg123/docker-wicket/index/mem"
+	"github.com/tg123/docker-wicket/pat"
+	"github.com/tg123/docker-wicket/robot"
+)
+
+// users log in with their password and own the namespace of their name
+type users map[acl.Username]acl.Password
+
+func (u users) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	p, ok := u[username]
+	return ok && p == password, nil
+}
+
+func (u users) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	return username != acl.Anonymous && string(username) == namespace, nil
+}
+
+// idToken is a password granting its subject, whatever the username, like an OIDC token
+const idToken = "id-token"
+
+type bearers struct {
+	users
+}
+
+func (b bearers) Authenticate(cred *acl.Credential) (acl.Session, error) {
+	if cred.Password == idToken {
+		return &bearerSession{cred.Username}, nil
+	}
+
+	return acl.Login(b.users, cred)
+}
+
+type bearerSession struct {
+	username acl.Username
+}
+
+func (s *bearerSession) Username() acl.Username {
+	return s.username
+}
+
+func (s *bearerSession) CanAccess(namespace, repo string, perm acl.Permission) (bool, error) {
+	return true, nil
+}
+
+type fixture struct {
+	handler http.Handler
+
+	robots *robot.FileStore
+	tokens *pat.FileStore
+}
+
+func newFixture(t *testing.T) *fixture {
+	return newFixtureOf(t, mem.New())
+}
+
+func newFixtureOf(t *testing.T, idx index.Driver) *fixture {
+
+	dir := t.TempDir()
+
+	f := &fixture{
+		robots: robot.NewFileStore(filepath.Join(dir, "robots.json")),
+		tokens: pat.NewFileStore(filepath.Join(dir, "tokens.json")),
+	}
+
+	router := web.New(handler.ShareWebContext{}).
+		Middleware((*handler.ShareWebContext).RequestLogger)
+
+	InstallHandler(router, &RunningContext{
+		RunningContext: handler.RunningContext{
+			Acl: pat.Wrap(f.tokens, robot.Wrap(f.robots, bearers{users{"admin": "pass", "user1": "pass1"}})),
+		},
+		Admins: []string{"admin"},
+		Robots: f.robots,
+		Tokens: f.tokens,
+		Index:  idx,
+	})
+
+	f.handler = router
+
+	return f
+}
+
+// token creates a token of owner, returns its password
+func (f *fixture) token(t *testing.T, owner acl.Username) string {
+
+	tk, password, err := pat.New(owner, acl.Grants{{Repository: "*", Actions: []string{"pull", "push"}}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.tokens.Create(tk); err != nil {
+		t.Fatal(err)
+	}
+
+	return password
+}
+
+// robot creates a robot, returns its username and secret
+func (f *fixture) robot(t *testing.T, name string) (string, string) {
+
+	r, secret, err := robot.New(name, acl.Grants{{Repository: "*", Actions: []string{"pull"}}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.robots.Create(r); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(r.Username()), secret
+}
+
+func (f *fixture) do(method, path, username, password, body string) int {
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.SetBasicAuth(username, password)
+
+	rw := httptest.NewRecorder()
+	f.handler.ServeHTTP(rw, req)
+
+	return rw.Code
+}
+
+func TestAdmin(t *testing.T) {
+
+	f := newFixture(t)
+
+	robotUser, robotSecret := f.robot(t, "ci")
+
+	for _, c := range []struct {
+		name     string
+		username string
+		password string
+		status   int
+	}{
+		{"admin by password", "admin", "pass", http.StatusCreated},
+		{"not an admin", "user1", "pass1", http.StatusForbidden},
+		{"token of admin", "admin", f.token(t, "admin"), http.StatusForbidden},
+		{"credential named as admin", "admin", idToken, http.StatusForbidden},
+		{"robot", robotUser, robotSecret, http.StatusForbidden},
+	} {
+		body := `{"name": "` + strings.ReplaceAll(c.name, " ", "-") + `", "grants": ["foo/*:pull"]}`
+
+		if got := f.do("POST", "/api/robots/", c.username, c.password, body); got != c.status {
+			t.Errorf("%v: create robot got %v, want %v", c.name, got, c.status)
+		}
+
+		if c.status != http.StatusCreated {
+			if got := f.do("GET", "/api/robots/", c.username, c.password, ""); got != c.status {
+				t.Errorf("%v: list robots got %v, want %v", c.name, got, c.status)
+			}
+
+			if got := f.do("DELETE", "/api/robots/ci", c.username, c.password, ""); got != c.status {
+				t.Errorf("%v: delete robot got %v, want %v", c.name, got, c.status)
+			}
+		}
+	}
+
+	if r, err := f.robots.Get("ci"); err != nil || r == nil {
+		t.Fatalf("robot deleted by non admin: %v", err)
+	}
+}
+
+func TestPrimary(t *testing.T) {
+
+	f := newFixture(t)
+
+	robotUser, robotSecret := f.robot(t, "ci")
+
+	body := `{"grants": ["user1/*:pull"]}`
+
+	if got := f.do("POST", "/api/tokens/", "user1", "pass1", body); got != http.StatusCreated {
+		t.Fatalf("create token by password: got %v", got)
+	}
+
+	for _, c := range []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"token", "user1", f.token(t, "user1")},
+		{"robot", robotUser, robotSecret},
+		{"credential", "user1", idToken},
+	} {
+		if got := f.do("POST", "/api/tokens/", c.username, c.password, body); got != http.StatusForbidden {
+			t.Errorf("%v: create token got %v, want %v", c.name, got, http.StatusForbidden)
+		}
+	}
+
+	if got := f.do("GET", "/api/tokens/", "user1", "pass1", ""); got != http.StatusOK {
+		t.Fatalf("list tokens by password: got %v", got)
+	}
+}
+
+func TestBackup(t *testing.T) {
+
+	dir := t.TempDir()
+
+	idx, err := bolt.Open(filepath.Join(dir, "index.bolt"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer idx.Close()
+
+	if err := idx.UpdateIndexImages("foo", "app", []index.Image{{Id: "abc"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFixtureOf(t, idx)
+
+	token := f.token(t, "admin")
+
+	for _, c := range []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"not an admin", "user1", "pass1"},
+		{"token of admin", "admin", token},
+		{"credential named as admin", "admin", idToken},
+	} {
+		if got := f.do("GET", "/api/index/backup", c.username, c.password, ""); got != http.StatusForbidden {
+			t.Errorf("%v: got %v, want %v", c.name, got, http.StatusForbidden)
+		}
+	}
+
+	// the index stays open and serving while backed up
+	req := httptest.NewRequest("GET", "/api/index/backup", nil)
+	req.SetBasicAuth("admin", "pass")
+
+	rw := httptest.NewRecorder()
+	f.handler.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("backup: got %v", rw.Code)
+	}
+
+	backup := filepath.Join(dir, "backup.bolt")
+
+	if err := os.WriteFile(backup, rw.Body.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := bolt.Open(backup, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer restored.Close()
+
+	images, err := restored.GetIndexImages("foo", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 1 || images[0].Id != "abc" {
+		t.Fatalf("backup has %+v", images)
+	}
+
+	// mem keeps nothing to copy
+	if got := newFixture(t).do("GET", "/api/index/backup", "admin", "pass", ""); got != http.StatusNotImplemented {
+		t.Fatalf("backup of mem: got %v, want %v", got, http.StatusNotImplemented)
+	}
+}
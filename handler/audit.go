@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/audit"
+)
+
+func loginMethod(cred *acl.Credential) string {
+	switch {
+	case cred.Verified:
+		return "certificate"
+	case cred.Username == acl.Anonymous:
+		return "anonymous"
+	}
+
+	return "basic"
+}
+
+// AuditEvent starts an event of req, the caller fills in the result
+func (rc *RunningContext) AuditEvent(req *http.Request, api, typ string, cred *acl.Credential) *audit.Event {
+
+	e := &audit.Event{
+		Type:      typ,
+		API:       api,
+		ClientIP:  ClientIP(req),
+		UserAgent: req.UserAgent(),
+	}
+
+	if cred != nil {
+		e.Username = string(cred.Username)
+		e.Method = loginMethod(cred)
+	}
+
+	return e
+}
+
+// AuditLogin records the result of acl.Login
+func (rc *RunningContext) AuditLogin(req *http.Request, api string, cred *acl.Credential, session acl.Session, err error) {
+
+	e := rc.AuditEvent(req, api, audit.Login, cred)
+
+	switch {
+	case err != nil:
+		e.Result = audit.Error
+		e.Error = err.Error()
+	case session == nil:
+		e.Result = audit.Failure
+	default:
+		e.Result = audit.Success
+		e.Identity = string(session.Username())
+	}
+
+	rc.Audit.Log(e)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/tg123/docker-wicket/acl"
+)
+
+// CertIdentity maps a verified client certificate to an acl username
+// templates are executed with the *x509.Certificate, the first non-empty result wins, say,
+//
+//	{{.Subject.CommonName}}
+//	{{range .DNSNames}}{{.}}{{end}}
+type CertIdentity struct {
+	templates []*template.Template
+}
+
+func NewCertIdentity(templates []string) (*CertIdentity, error) {
+
+	ci := &CertIdentity{}
+
+	for _, s := range templates {
+		t, err := template.New("").Option("missingkey=error").Parse(s)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ci.templates = append(ci.templates, t)
+	}
+
+	return ci, nil
+}
+
+func (ci *CertIdentity) Username(cert *x509.Certificate) (acl.Username, bool) {
+
+	for _, t := range ci.templates {
+		var b bytes.Buffer
+
+		if err := t.Execute(&b, cert); err != nil {
+			continue
+		}
+
+		if u := strings.TrimSpace(b.String()); u != "" {
+			return acl.Username(u), true
+		}
+	}
+
+	return acl.Anonymous, false
+}
+
+// Credential returns what req presents for login, a verified client certificate is preferred over Basic auth
+func (rc *RunningContext) Credential(req *http.Request) *acl.Credential {
+
+	cred := &acl.Credential{
+		Username:   acl.Anonymous,
+		RemoteAddr: ClientIP(req),
+	}
+
+	if rc.ClientCerts != nil && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		if u, ok := rc.ClientCerts.Username(req.TLS.VerifiedChains[0][0]); ok {
+			cred.Username = u
+			cred.Verified = true
+
+			return cred
+		}
+	}
+
+	if username, password, ok := req.BasicAuth(); ok {
+		cred.Username = acl.Username(username)
+		cred.Password = acl.Password(password)
+	}
+
+	return cred
+}
@@ -1,18 +1,156 @@
 package handler
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
 	"github.com/gocraft/web"
 
 	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/audit"
 )
 
 type ShareWebContext struct {
+	RequestID string
+
+	// logs with request_id
+	Log *slog.Logger
 }
 
 type RunningContext struct {
 	TokenAuth *TokenAuth
 	Acl       acl.Driver
+
+	// nil if client certificates are not accepted as identity
+	ClientCerts *CertIdentity
+
+	// nil drops audit events
+	Audit *audit.Logger
+}
+
+// WithContext returns rc with drivers serving the request of ctx, see acl.WithContext
+func (rc RunningContext) WithContext(ctx context.Context) RunningContext {
+	rc.Acl = acl.WithContext(rc.Acl, ctx)
+	return rc
 }
 
 func Empty(rw web.ResponseWriter, req *web.Request) {
 }
+
+// ClientIP returns ip of the client, see RealIP for clients behind a proxy
+func ClientIP(req *http.Request) string {
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// ParseTrustedProxies parses CIDRs, or single ips, of proxies in front
+func ParseTrustedProxies(l []string) ([]*net.IPNet, error) {
+
+	nets := make([]*net.IPNet, 0, len(l))
+
+	for _, s := range l {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+
+			if ip == nil {
+				return nil, fmt.Errorf("bad trusted proxy %q", s)
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad trusted proxy %q: %v", s, err)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func trusted(nets []*net.IPNet, ip string) bool {
+
+	parsed := net.ParseIP(ip)
+
+	if parsed == nil {
+		return false
+	}
+
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RealIP sets req.RemoteAddr to the client's when the peer is a trusted proxy,
+// from X-Real-IP, or the rightmost untrusted hop of X-Forwarded-For, headers of others are ignored
+func RealIP(nets []*net.IPNet) func(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	return func(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+
+		if !trusted(nets, ClientIP(req.Request)) {
+			next(rw, req)
+			return
+		}
+
+		if ip := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+			req.RemoteAddr = ip
+			next(rw, req)
+			return
+		}
+
+		// each proxy appends the peer it got the request from, a client can only forge the left part
+		hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+
+			if net.ParseIP(ip) == nil {
+				break
+			}
+
+			req.RemoteAddr = ip
+
+			if !trusted(nets, ip) {
+				break
+			}
+		}
+
+		next(rw, req)
+	}
+}
+
+// Readiness tells whether the server takes new requests, it is flipped off before draining
+type Readiness struct {
+	ready int32
+}
+
+func (r *Readiness) Set(ready bool) {
+	var v int32
+
+	if ready {
+		v = 1
+	}
+
+	atomic.StoreInt32(&r.ready, v)
+}
+
+func (r *Readiness) Ready() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocraft/web"
+)
+
+func TestRealIP(t *testing.T) {
+
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+
+	router := web.New(ShareWebContext{}).
+		Middleware(RealIP(proxies)).
+		Get("/", func(rw web.ResponseWriter, req *web.Request) {
+			got = ClientIP(req.Request)
+		})
+
+	for _, c := range []struct {
+		name   string
+		peer   string
+		header map[string]string
+		want   string
+	}{
+		{"no proxy", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted peer forging", "203.0.113.5:1234", map[string]string{"X-Real-IP": "1.2.3.4", "X-Forwarded-For": "1.2.3.4"}, "203.0.113.5"},
+		{"trusted x-real-ip", "10.1.2.3:1234", map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7"},
+		{"single trusted ip", "192.0.2.1:1234", map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7"},
+		{"trusted x-forwarded-for", "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"forged left hop", "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7"}, "198.51.100.7"},
+		{"chained proxies", "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "198.51.100.7, 10.9.9.9"}, "198.51.100.7"},
+		{"garbage", "10.1.2.3:1234", map[string]string{"X-Real-IP": "nonsense"}, "10.1.2.3"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.peer
+
+		for k, v := range c.header {
+			req.Header.Set(k, v)
+		}
+
+		got = ""
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got != c.want {
+			t.Errorf("%v: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, bad := range []string{"nonsense", "10.0.0.0/33", ""} {
+		if _, err := ParseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package health
+
+// /healthz and /readyz for orchestrators
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/tg123/docker-wicket/handler"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/index"
+)
+
+type RunningContext struct {
+	handler.RunningContext
+
+	// nil if v1 is not served
+	Index index.Driver
+
+	Readiness *handler.Readiness
+
+	// signing cert expiring within is unhealthy
+	CertExpiryWarning time.Duration
+}
+
+type context struct {
+	*handler.ShareWebContext
+
+	rc *RunningContext
+}
+
+// Handler serves requests with its RunningContext, which can be swapped by Update
+type Handler struct {
+	rc atomic.Pointer[RunningContext]
+}
+
+type status struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type report struct {
+	status
+
+	Components map[string]*status `json:"components"`
+}
+
+func newStatus(err error) *status {
+	if err != nil {
+		return &status{Status: "fail", Error: err.Error()}
+	}
+
+	return &status{Status: "ok"}
+}
+
+func (c *context) check() *report {
+
+	r := &report{
+		status:     status{Status: "ok"},
+		Components: make(map[string]*status),
+	}
+
+	r.Components["token"] = newStatus(c.rc.TokenAuth.Check(c.rc.CertExpiryWarning))
+	r.Components["acl"] = newStatus(acl.Check(c.rc.Acl))
+
+	if c.rc.Index != nil {
+		r.Components["index"] = newStatus(index.Check(c.rc.Index))
+	}
+
+	for _, s := range r.Components {
+		if s.Status != "ok" {
+			r.Status = "fail"
+		}
+	}
+
+	return r
+}
+
+func write(rw web.ResponseWriter, r *report) {
+
+	b, err := json.Marshal(r)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+
+	if r.Status != "ok" {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	rw.Write(b)
+}
+
+func (c *context) healthz(rw web.ResponseWriter, req *web.Request) {
+	write(rw, c.check())
+}
+
+// same as healthz, but also not ready when shutting down
+func (c *context) readyz(rw web.ResponseWriter, req *web.Request) {
+	r := c.check()
+
+	if !c.rc.Readiness.Ready() {
+		r.Status = "fail"
+		r.Components["server"] = &status{Status: "fail", Error: "not ready"}
+	} else {
+		r.Components["server"] = &status{Status: "ok"}
+	}
+
+	write(rw, r)
+}
+
+// requests in flight keep the RunningContext they started with
+func (h *Handler) loadRunningContext(c *context, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	c.rc = h.rc.Load()
+	next(rw, req)
+}
+
+// Update swaps rc in for new requests, say, on reload
+func (h *Handler) Update(rc *RunningContext) {
+	h.rc.Store(rc)
+}
+
+func InstallHandler(rootRouter *web.Router, rc *RunningContext) *Handler {
+
+	h := &Handler{}
+	h.rc.Store(rc)
+
+	c := context{}
+
+	rootRouter.Subrouter(c, "").
+		Middleware(h.loadRunningContext).
+		Get("/healthz", (*context).healthz).
+		Get("/readyz", (*context).readyz)
+
+	return h
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/gocraft/web"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tg123/docker-wicket/requestid"
+)
+
+// request id from the proxy in front is kept if it looks sane
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Logger is the request's logger, or the default one outside RequestLogger
+func (c *ShareWebContext) Logger() *slog.Logger {
+	if c == nil || c.Log == nil {
+		return slog.Default()
+	}
+
+	return c.Log
+}
+
+// RequestLogger gives every request an id, in its context too, and a logger with it, and logs the request when done
+func (c *ShareWebContext) RequestLogger(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	start := time.Now()
+
+	c.RequestID = req.Header.Get("X-Request-ID")
+
+	if !validRequestID.MatchString(c.RequestID) {
+		c.RequestID = newRequestID()
+	}
+
+	c.Log = slog.Default().With("request_id", c.RequestID)
+
+	// for drivers, bound to the request by RunningContext.WithContext
+	req.Request = req.Request.WithContext(requestid.With(req.Context(), c.RequestID))
+
+	// set by tracing.Middleware in front
+	if sc := trace.SpanContextFromContext(req.Context()); sc.HasTraceID() {
+		c.Log = c.Log.With("trace_id", sc.TraceID().String())
+	}
+
+	rw.Header().Set("X-Request-ID", c.RequestID)
+
+	next(rw, req)
+
+	c.Log.Info("request",
+		"method", req.Method,
+		"path", req.URL.Path,
+		"status", rw.StatusCode(),
+		"size", rw.Size(),
+		"duration", time.Since(start),
+		"client_ip", ClientIP(req.Request),
+	)
+}
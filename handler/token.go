@@ -5,7 +5,9 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"strings"
 	"time"
@@ -22,6 +24,7 @@ type TokenAuth struct {
 	Service    string
 	Expiration int64
 
+	cert        *x509.Certificate
 	publicKey   libtrust.PublicKey
 	privateKey  libtrust.PrivateKey
 	rootCerts   *x509.CertPool
@@ -63,26 +66,85 @@ func (t *TokenAuth) LoadCertAndKey(certFile, keyFile string) error {
 		return err
 	}
 
-	t.publicKey = pk
 	t.privateKey = prk
+	t.trustCert(cert, pk)
+
+	return nil
+}
+
+// LoadCert loads only the certificate, which is enough to verify tokens but not to sign them
+func (t *TokenAuth) LoadCert(certFile string) error {
+
+	b, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return fmt.Errorf("no PEM data found in %v", certFile)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	pk, err := libtrust.FromCryptoPublicKey(cert.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	t.trustCert(cert, pk)
+
+	return nil
+}
+
+func (t *TokenAuth) trustCert(cert *x509.Certificate, pk libtrust.PublicKey) {
+	t.cert = cert
+	t.publicKey = pk
 
 	t.rootCerts = x509.NewCertPool()
 	t.rootCerts.AddCert(cert)
 
 	t.trustedKeys = make(map[string]libtrust.PublicKey, 1)
 	t.trustedKeys[pk.KeyID()] = pk
+}
+
+// Check fails if the signing key is not loaded, or the cert expires within d
+func (t *TokenAuth) Check(d time.Duration) error {
+
+	if t.privateKey == nil || t.cert == nil {
+		return fmt.Errorf("signing key not loaded")
+	}
+
+	if time.Now().Add(d).After(t.cert.NotAfter) {
+		return fmt.Errorf("signing cert expires at %v", t.cert.NotAfter.Format(time.RFC3339))
+	}
 
 	return nil
 }
 
-func (t *TokenAuth) Verify(rawToken string, fn func(access ResourceActions) error) error {
+// CertNotAfter is when the signing cert expires, zero if not loaded
+func (t *TokenAuth) CertNotAfter() time.Time {
+	if t.cert == nil {
+		return time.Time{}
+	}
+
+	return t.cert.NotAfter
+}
 
-	verifyOpts := token.VerifyOptions{
+// VerifyOptions are the options a registry configured with the same cert, issuer and service would use
+func (t *TokenAuth) VerifyOptions() token.VerifyOptions {
+	return token.VerifyOptions{
 		TrustedIssuers:    []string{t.Issuer},
 		AcceptedAudiences: []string{t.Service},
 		Roots:             t.rootCerts,
 		TrustedKeys:       t.trustedKeys,
 	}
+}
+
+func (t *TokenAuth) Verify(rawToken string, fn func(access ResourceActions) error) error {
 
 	token, err := token.NewToken(rawToken)
 
@@ -90,7 +152,7 @@ func (t *TokenAuth) Verify(rawToken string, fn func(access ResourceActions) erro
 		return err
 	}
 
-	err = token.Verify(verifyOpts)
+	err = token.Verify(t.VerifyOptions())
 	if err != nil {
 		return err
 	}
@@ -98,14 +160,20 @@ func (t *TokenAuth) Verify(rawToken string, fn func(access ResourceActions) erro
 	return fn(token.Claims.Access)
 }
 
-// https://github.com/docker/distribution/blob/master/docs/spec/auth/token.md#example
 func (t *TokenAuth) CreateToken(ar *AuthRequest) (string, error) {
+	token, _, err := t.IssueToken(ar)
+	return token, err
+}
+
+// IssueToken is CreateToken, but also returns the jti of the token
+// https://github.com/docker/distribution/blob/master/docs/spec/auth/token.md#example
+func (t *TokenAuth) IssueToken(ar *AuthRequest) (string, string, error) {
 	now := time.Now().Unix()
 
 	// Sign something dummy to find out which algorithm is used.
 	_, sigAlg, err := t.privateKey.Sign(strings.NewReader("dummy"), 0)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign: %s", err)
+		return "", "", fmt.Errorf("failed to sign: %s", err)
 	}
 	header := token.Header{
 		Type:       "JWT",
@@ -114,7 +182,7 @@ func (t *TokenAuth) CreateToken(ar *AuthRequest) (string, error) {
 	}
 	headerJSON, err := json.Marshal(header)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal header: %s", err)
+		return "", "", fmt.Errorf("failed to marshal header: %s", err)
 	}
 
 	claims := token.ClaimSet{
@@ -134,17 +202,17 @@ func (t *TokenAuth) CreateToken(ar *AuthRequest) (string, error) {
 	}
 	claimsJSON, err := json.Marshal(claims)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal claims: %s", err)
+		return "", "", fmt.Errorf("failed to marshal claims: %s", err)
 	}
 
 	payload := fmt.Sprintf("%s%s%s", joseBase64UrlEncode(headerJSON), token.TokenSeparator, joseBase64UrlEncode(claimsJSON))
 
 	sig, sigAlg2, err := t.privateKey.Sign(strings.NewReader(payload), 0)
 	if err != nil || sigAlg2 != sigAlg {
-		return "", fmt.Errorf("failed to sign token: %s", err)
+		return "", "", fmt.Errorf("failed to sign token: %s", err)
 	}
 
-	return fmt.Sprintf("%s%s%s", payload, token.TokenSeparator, joseBase64UrlEncode(sig)), nil
+	return fmt.Sprintf("%s%s%s", payload, token.TokenSeparator, joseBase64UrlEncode(sig)), claims.JWTID, nil
 }
 
 // Copy-pasted from libtrust where it is private.
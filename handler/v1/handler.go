@@ -5,14 +5,22 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gocraft/web"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/tg123/docker-wicket/handler"
 
 	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/audit"
 	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/metrics"
+	"github.com/tg123/docker-wicket/tracing"
 )
 
 type RunningContext struct {
@@ -28,7 +36,10 @@ type permission struct {
 	name string
 }
 
-var runningContext *RunningContext
+// Handler serves requests with its RunningContext, which can be swapped by Update
+type Handler struct {
+	rc atomic.Pointer[RunningContext]
+}
 
 var accessMap = map[string]permission{
 	"GET":    {acl.READ, "read"},
@@ -41,11 +52,17 @@ type context struct {
 
 	namespace string
 	repo      string
+
+	// for audit
+	cred     *acl.Credential
+	identity acl.Username
+
+	rc *RunningContext
 }
 
 func (c *context) checkSignature(namespace, repo, signature, access string) bool {
 
-	return runningContext.TokenAuth.Verify(signature, func(resourceActions handler.ResourceActions) error {
+	return c.rc.TokenAuth.Verify(signature, func(resourceActions handler.ResourceActions) error {
 
 		for _, r := range resourceActions {
 
@@ -63,6 +80,13 @@ func (c *context) checkSignature(namespace, repo, signature, access string) bool
 	}) == nil
 }
 
+func (c *context) repoAttributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("wicket.namespace", c.namespace),
+		attribute.String("wicket.repo", c.repo),
+	}
+}
+
 func (c *context) commonHeader(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
 	rw.Header().Set("X-Docker-Registry-Version", "0.9.1")
 	next(rw, req)
@@ -77,71 +101,112 @@ func (c *context) ping(rw web.ResponseWriter, req *web.Request) {
 
 //
 
-func (c *context) authAccess(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+// login logs in cred with the acl driver, traced, logged and audited
+func (c *context) login(req *web.Request, cred *acl.Credential) (acl.Session, error) {
 
-	// Authorization: Token signature=123,repository="library/test",access=write
-	a, ok := req.Header["Authorization"]
+	start := time.Now()
 
-	if ok {
-		m := make(map[string]string)
+	_, span := tracing.Start(req.Context(), "acl.Login", attribute.String("wicket.username", string(cred.Username)))
 
-		s := strings.TrimLeft(a[0], "Token ")
+	session, err := acl.Login(c.rc.Acl, cred)
 
-		for _, p := range strings.Split(s, ",") {
+	span.SetAttributes(attribute.Bool("wicket.acl.ok", session != nil))
+	tracing.End(span, err)
 
-			kv := strings.Split(p, "=")
+	c.Logger().Debug("acl login", "username", cred.Username, "ok", session != nil, "duration", time.Since(start))
 
-			k := kv[0]
-			v := kv[1]
+	c.rc.AuditLogin(req.Request, "v1", cred, session, err)
 
-			m[k] = v
-		}
+	if err != nil {
+		c.Logger().Error("acl login failed", "username", cred.Username, "err", err)
+	}
 
-		nr := strings.SplitN(strings.Trim(m["repository"], `"`), "/", 2)
+	return session, err
+}
 
-		m["namespace"] = nr[0]
-		m["repo"] = nr[1]
+// authUser is docker login, which only checks the credential, 401 tells the client it is wrong
+func (c *context) authUser(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
 
-		if c.checkSignature(nr[0], nr[1], m["signature"], m["access"]) {
-			next(rw, req)
-			return
-		}
+	cred := c.rc.Credential(req.Request)
 
+	// Anonymous cant login
+	if cred.Username == acl.Anonymous {
+		http.Error(rw, "", http.StatusUnauthorized)
+		return
 	}
 
-	// Authorization: Basic
-	username, password, ok := req.BasicAuth()
+	session, err := c.login(req, cred)
 
-	var _username acl.Username
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	if ok {
-		_username = acl.Username(username)
-	} else {
-		_username = acl.Anonymous
+	if session == nil {
+		http.Error(rw, "", http.StatusUnauthorized)
+		return
 	}
 
-	// TODO should move to a separate func
-	// happens when login
-	if c.namespace == "" || c.repo == "" {
+	next(rw, req)
+}
 
-		// Anonymous cant login
-		if _username == acl.Anonymous {
-			http.Error(rw, "", http.StatusUnauthorized)
+func (c *context) authAccess(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+
+	span := tracing.Span(req, "v1.authAccess", c.repoAttributes()...)
+	defer span.End()
+
+	// Authorization: Token signature=123,repository="library/test",access=write
+	if a := req.Header.Get("Authorization"); strings.HasPrefix(a, "Token ") {
+		m := make(map[string]string)
+
+		for _, p := range strings.Split(strings.TrimPrefix(a, "Token "), ",") {
+			if k, v, ok := strings.Cut(p, "="); ok {
+				m[k] = v
+			}
 		}
 
-		return
+		_, verify := tracing.Start(req.Context(), "token.Verify")
+
+		valid := c.checkSignature(c.namespace, c.repo, m["signature"], m["access"])
+
+		verify.SetAttributes(attribute.Bool("wicket.token.ok", valid))
+		verify.End()
+
+		// the signature is checked against the repo of the path, not the one the header claims
+		e := c.rc.AuditEvent(req.Request, "v1", audit.Access, nil)
+		e.Method = "token"
+		e.ScopeType = "repository"
+		e.ScopeName = fmt.Sprintf("%v/%v", c.namespace, c.repo)
+
+		if valid {
+			e.Result = audit.Success
+			e.Granted = []string{m["access"]}
+			c.rc.Audit.Log(e)
+
+			next(rw, req)
+			return
+		}
+
+		e.Result = audit.Failure
+		e.Denied = []string{m["access"]}
+		c.rc.Audit.Log(e)
 	}
 
-	ok, err := runningContext.Acl.CanLogin(_username, acl.Password(password))
+	// client certificate or Authorization: Basic
+	cred := c.rc.Credential(req.Request)
+
+	span.SetAttributes(attribute.String("wicket.username", string(cred.Username)))
+
+	session, err := c.login(req, cred)
 
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if !ok {
+	if session == nil {
 
-		if _username == acl.Anonymous {
+		if cred.Username == acl.Anonymous {
 			http.Error(rw, "", http.StatusUnauthorized)
 		} else {
 			http.Error(rw, "", http.StatusForbidden)
@@ -159,9 +224,38 @@ func (c *context) authAccess(rw web.ResponseWriter, req *web.Request, next web.N
 			return
 		}
 
-		ok, err = runningContext.Acl.CanAccess(_username, c.namespace, c.repo, a.Permission)
+		start := time.Now()
+
+		_, access := tracing.Start(req.Context(), "acl.CanAccess", attribute.String("wicket.access", a.name))
+
+		ok, err = session.CanAccess(c.namespace, c.repo, a.Permission)
+
+		access.SetAttributes(attribute.Bool("wicket.acl.ok", ok))
+		tracing.End(access, err)
+
+		c.Logger().Debug("acl access", "identity", session.Username(), "namespace", c.namespace, "repo", c.repo, "access", a.name, "ok", ok, "duration", time.Since(start))
+
+		e := c.rc.AuditEvent(req.Request, "v1", audit.Access, cred)
+		e.Identity = string(session.Username())
+		e.ScopeType = "repository"
+		e.ScopeName = fmt.Sprintf("%v/%v", c.namespace, c.repo)
+
+		switch {
+		case err != nil:
+			e.Result = audit.Error
+			e.Error = err.Error()
+		case ok:
+			e.Result = audit.Success
+			e.Granted = []string{a.name}
+		default:
+			e.Result = audit.Failure
+			e.Denied = []string{a.name}
+		}
+
+		c.rc.Audit.Log(e)
 
 		if err != nil {
+			c.Logger().Error("acl access failed", "identity", session.Username(), "err", err)
 			http.Error(rw, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -172,6 +266,11 @@ func (c *context) authAccess(rw web.ResponseWriter, req *web.Request, next web.N
 		}
 	}
 
+	c.cred = cred
+	c.identity = session.Username()
+
+	span.SetAttributes(attribute.String("wicket.identity", string(c.identity)))
+
 	next(rw, req)
 }
 
@@ -200,20 +299,46 @@ func (c *context) generateToken(rw web.ResponseWriter, req *web.Request, next we
 			return
 		}
 
-		sig, err := runningContext.TokenAuth.CreateToken(&handler.AuthRequest{
+		span := tracing.Span(req, "v1.generateToken", attribute.String("wicket.access", a.name))
+		defer span.End()
+
+		sig, jti, err := c.rc.TokenAuth.IssueToken(&handler.AuthRequest{
 			Name:    fmt.Sprintf("%v/%v", c.namespace, c.repo),
 			Actions: []string{a.name},
-			Service: runningContext.TokenAuth.Service,
+			Service: c.rc.TokenAuth.Service,
 		})
 
-		if !ok {
+		e := c.rc.AuditEvent(req.Request, "v1", audit.Token, c.cred)
+		e.Identity = string(c.identity)
+		e.ScopeType = "repository"
+		e.ScopeName = fmt.Sprintf("%v/%v", c.namespace, c.repo)
+		e.Granted = []string{a.name}
+		e.TokenID = jti
+
+		if err != nil {
+			e.Result = audit.Error
+			e.Error = err.Error()
+			c.rc.Audit.Log(e)
+
+			tracing.Fail(span, err)
+
+			c.Logger().Error("cannot create token", "err", err)
 			http.Error(rw, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		e.Result = audit.Success
+		c.rc.Audit.Log(e)
+
+		c.Logger().Debug("token issued", "identity", c.identity, "repository", e.ScopeName, "access", a.name, "jti", jti)
+
+		span.SetAttributes(attribute.String("wicket.token.id", jti))
+
+		metrics.TokenIssued("v1", []string{a.name})
+
 		t := fmt.Sprintf(`signature=%v,repository="%v/%v",access=%v`, sig, c.namespace, c.repo, a.name)
 
-		rw.Header().Set("X-Docker-Endpoints", runningContext.Endpoints)
+		rw.Header().Set("X-Docker-Endpoints", c.rc.Endpoints)
 		rw.Header().Set("WWW-Authenticate", "Token "+t)
 		rw.Header().Set("X-Docker-Token", t)
 	}
@@ -229,26 +354,31 @@ func (c *context) getImages(rw web.ResponseWriter, req *web.Request) {
 
 	rw.Header().Set("Content-Type", "application/json")
 
-	m, err := runningContext.Index.GetIndexImages(c.namespace, c.repo)
+	_, span := tracing.Start(req.Context(), "index.GetIndexImages", c.repoAttributes()...)
+
+	m, err := c.rc.Index.GetIndexImages(c.namespace, c.repo)
+
+	tracing.End(span, err)
+
+	c.Logger().Debug("index get images", "namespace", c.namespace, "repo", c.repo, "images", len(m))
 
 	if err != nil {
+		c.Logger().Error("index get images failed", "namespace", c.namespace, "repo", c.repo, "err", err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	b, err := json.Marshal(m)
 
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	rw.Write(b)
 }
 
 func (c *context) updateImageIndex(req *web.Request) error {
-	images, err := runningContext.Index.GetIndexImages(c.namespace, c.repo)
-	if err != nil {
-		return err
-	}
 
 	newImages := make([]index.Image, 0)
 
@@ -265,34 +395,29 @@ func (c *context) updateImageIndex(req *web.Request) error {
 		return err
 	}
 
-	images = append(images, newImages...)
+	_, span := tracing.Start(req.Context(), "index.UpdateIndex", append(c.repoAttributes(), attribute.Int("wicket.images", len(newImages)))...)
 
-	m := make(map[string]index.Image)
+	// merged under the driver's lock, or concurrent pushes to the repo lose images
+	err = c.rc.Index.UpdateIndex(c.namespace, c.repo, func(images []index.Image) ([]index.Image, error) {
 
-	for _, i := range images {
+		images = index.Merge(images, newImages, string(c.identity), time.Now())
 
-		if _, ok := m[i.Id]; !ok {
-			m[i.Id] = i
-		} else if m[i.Id].Checksum == "" {
-			m[i.Id] = i
-		}
-	}
+		c.Logger().Debug("index update images", "namespace", c.namespace, "repo", c.repo, "images", len(images))
 
-	images = make([]index.Image, len(m))
+		return images, nil
+	})
 
-	i := 0
-	for _, v := range m {
-		images[i] = v
-		i++
-	}
+	tracing.End(span, err)
 
-	return runningContext.Index.UpdateIndexImages(c.namespace, c.repo, images)
+	return err
 }
 
 func (c *context) createImages(rw web.ResponseWriter, req *web.Request) {
 
 	if err := c.updateImageIndex(req); err != nil {
+		c.Logger().Error("index update images failed", "namespace", c.namespace, "repo", c.repo, "err", err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	http.Error(rw, "", http.StatusNoContent)
@@ -300,8 +425,22 @@ func (c *context) createImages(rw web.ResponseWriter, req *web.Request) {
 
 func (c *context) createRepo(rw web.ResponseWriter, req *web.Request) {
 
+	_, span := tracing.Start(req.Context(), "index.CreateRepo", c.repoAttributes()...)
+
+	err := c.rc.Index.CreateRepo(c.namespace, c.repo)
+
+	tracing.End(span, err)
+
+	if err != nil {
+		c.Logger().Error("index create repo failed", "namespace", c.namespace, "repo", c.repo, "err", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	if err := c.updateImageIndex(req); err != nil {
+		c.Logger().Error("index update images failed", "namespace", c.namespace, "repo", c.repo, "err", err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	http.Error(rw, "", http.StatusOK)
@@ -309,26 +448,173 @@ func (c *context) createRepo(rw web.ResponseWriter, req *web.Request) {
 
 func (c *context) deleteRepo(rw web.ResponseWriter, req *web.Request) {
 
-	if err := runningContext.Index.DeleteRepo(c.namespace, c.repo); err != nil {
+	c.Logger().Debug("index delete repo", "namespace", c.namespace, "repo", c.repo)
+
+	_, span := tracing.Start(req.Context(), "index.DeleteRepo", c.repoAttributes()...)
+
+	err := c.rc.Index.DeleteRepo(c.namespace, c.repo)
+
+	tracing.End(span, err)
+
+	if err == index.ErrRepoNotFound {
+		http.Error(rw, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		c.Logger().Error("index delete repo failed", "namespace", c.namespace, "repo", c.repo, "err", err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	http.Error(rw, "", http.StatusNoContent)
 }
 
-func InstallHandler(rootRouter *web.Router, rc *RunningContext) {
+type searchResult struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type searchResults struct {
+	NumPages   int            `json:"num_pages"`
+	NumResults int            `json:"num_results"`
+	Results    []searchResult `json:"results"`
+	Page       int            `json:"page"`
+	PageSize   int            `json:"page_size"`
+	Query      string         `json:"query"`
+}
+
+// formInt is the int in form key, def if not set or bad
+func formInt(req *web.Request, key string, def int) int {
+	if i, err := strconv.Atoi(req.FormValue(key)); err == nil && i > 0 {
+		return i
+	}
+
+	return def
+}
+
+// search lists repos the caller can read, like docker-registry's GET /v1/search?q=&n=25&page=1
+func (c *context) search(rw web.ResponseWriter, req *web.Request) {
+
+	rw.Header().Set("Content-Type", "application/json")
+
+	// anonymous searches what the driver lets anonymous read, if it lets anonymous log in
+	session, err := c.login(req, c.rc.Credential(req.Request))
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if session == nil {
+		http.Error(rw, "", http.StatusUnauthorized)
+		return
+	}
+
+	r := searchResults{
+		Query:    req.FormValue("q"),
+		Page:     formInt(req, "page", 1),
+		PageSize: formInt(req, "n", 25),
+		Results:  make([]searchResult, 0),
+	}
+
+	if r.PageSize > 100 {
+		r.PageSize = 100
+	}
+
+	_, span := tracing.Start(req.Context(), "index.Search", attribute.String("wicket.query", r.Query))
+
+	repos, err := c.rc.Index.Search(r.Query)
+
+	tracing.End(span, err)
+
+	if err != nil {
+		c.Logger().Error("index search failed", "query", r.Query, "err", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name() < repos[j].Name() })
+
+	// pages are of what the caller can read, every repo is checked so num_results and num_pages count all
+	first := (r.Page - 1) * r.PageSize
+
+	readable := 0
+
+	// one span for the lot, a search may check many repos
+	_, access := tracing.Start(req.Context(), "acl.CanAccess", attribute.String("wicket.access", "read"))
+
+	for _, repo := range repos {
+		ok, err := session.CanAccess(repo.Namespace, repo.Repo, acl.READ)
+
+		if err != nil {
+			tracing.End(access, err)
+			c.Logger().Error("acl access failed", "identity", session.Username(), "err", err)
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !ok {
+			continue
+		}
+
+		if readable >= first && readable < r.Page*r.PageSize {
+			// docker clients expect description, there is none kept
+			r.Results = append(r.Results, searchResult{Name: repo.Name()})
+		}
+
+		readable++
+	}
+
+	access.SetAttributes(attribute.Int("wicket.acl.checks", len(repos)), attribute.Int("wicket.acl.readable", readable))
+	tracing.End(access, nil)
+
+	r.NumResults = readable
+	r.NumPages = (r.NumResults + r.PageSize - 1) / r.PageSize
+
+	c.Logger().Debug("index search", "identity", session.Username(), "query", r.Query, "results", r.NumResults)
+
+	b, err := json.Marshal(&r)
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Write(b)
+}
+
+// requests in flight keep the RunningContext they started with, its drivers bound to the request, say, to log its id
+func (h *Handler) loadRunningContext(c *context, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	rc := *h.rc.Load()
+	rc.RunningContext = rc.RunningContext.WithContext(req.Context())
+	rc.Index = index.WithContext(rc.Index, req.Context())
+	c.rc = &rc
+
+	next(rw, req)
+}
+
+// Update swaps rc in for new requests, say, on reload
+func (h *Handler) Update(rc *RunningContext) {
+	h.rc.Store(rc)
+}
+
+func InstallHandler(rootRouter *web.Router, rc *RunningContext) *Handler {
 
-	runningContext = rc
+	h := &Handler{}
+	h.rc.Store(rc)
 
 	c := context{}
 
 	v1 := rootRouter.Subrouter(c, "/v1").
+		Middleware(h.loadRunningContext).
 		Middleware((*context).commonHeader).
 		Get("/", (*context).ping).
-		Get("/_ping", (*context).ping)
+		Get("/_ping", (*context).ping).
+		Get("/search", (*context).search)
 
 	v1.Subrouter(c, "/users").
-		Middleware((*context).authAccess).
+		Middleware((*context).authUser).
 		Get("/", handler.Empty).
 		Post("/", handler.Empty).
 		Put("/", handler.Empty)
@@ -353,4 +639,6 @@ func InstallHandler(rootRouter *web.Router, rc *RunningContext) {
 		Put("/:namespace/:repo/", (*context).createRepo).
 		Delete("/:repo/", (*context).deleteRepo).
 		Delete("/:namespace/:repo", (*context).deleteRepo)
+
+	return h
 }
@@ -0,0 +1,228 @@
+package v1
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/handler"
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/index/mem"
+)
+
+// newTokenAuth signs with a self signed cert
+func newTokenAuth(t *testing.T) *handler.TokenAuth {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "wicket"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	certFile := filepath.Join(dir, "token.crt")
+	keyFile := filepath.Join(dir, "token.key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ta := &handler.TokenAuth{Issuer: "wicket", Service: "registry", Expiration: 600}
+
+	if err := ta.LoadCertAndKey(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	return ta
+}
+
+// users log in with their password and own the namespace of their name
+type users map[acl.Username]acl.Password
+
+func (u users) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	p, ok := u[username]
+	return ok && p == password, nil
+}
+
+func (u users) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	return username != acl.Anonymous && string(username) == namespace, nil
+}
+
+type request struct {
+	method   string
+	path     string
+	username string
+	password string
+	header   map[string]string
+	body     string
+}
+
+func (q *request) do(h http.Handler) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(q.method, q.path, strings.NewReader(q.body))
+
+	if q.username != "" {
+		req.SetBasicAuth(q.username, q.password)
+	}
+
+	for k, v := range q.header {
+		req.Header.Set(k, v)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+
+	return rw
+}
+
+func newRouter(t *testing.T, idx index.Driver) (*web.Router, *Handler) {
+
+	router := web.New(handler.ShareWebContext{})
+
+	h := InstallHandler(router, &RunningContext{
+		RunningContext: handler.RunningContext{
+			TokenAuth: newTokenAuth(t),
+			Acl:       users{"user1": "pass1", "user2": "pass2"},
+		},
+		Endpoints: "registry.example.com",
+		Index:     idx,
+	})
+
+	return router, h
+}
+
+func images(t *testing.T, h http.Handler, path string) []index.Image {
+
+	rw := (&request{method: "GET", path: path, username: "user1", password: "pass1"}).do(h)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("get %v: got %v", path, rw.Code)
+	}
+
+	var l []index.Image
+
+	if err := json.Unmarshal(rw.Body.Bytes(), &l); err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestPush(t *testing.T) {
+
+	router, _ := newRouter(t, mem.New())
+
+	rw := (&request{
+		method:   "PUT",
+		path:     "/v1/repositories/user1/app/",
+		username: "user1",
+		password: "pass1",
+		header:   map[string]string{"X-Docker-Token": "true"},
+		body:     `[{"id": "abc"}]`,
+	}).do(router)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("push: got %v", rw.Code)
+	}
+
+	token := rw.Header().Get("X-Docker-Token")
+
+	if !strings.Contains(token, `repository="user1/app",access=write`) || rw.Header().Get("X-Docker-Endpoints") != "registry.example.com" {
+		t.Fatalf("bad token %q", token)
+	}
+
+	// the token alone, for the repo it is issued for
+	auth := map[string]string{"Authorization": "Token " + token}
+
+	if rw := (&request{method: "PUT", path: "/v1/repositories/user1/app/images", header: auth, body: `[{"id": "def"}]`}).do(router); rw.Code != http.StatusNoContent {
+		t.Fatalf("images with token: got %v", rw.Code)
+	}
+
+	if rw := (&request{method: "PUT", path: "/v1/repositories/user2/app/images", header: auth, body: "[]"}).do(router); rw.Code/100 == 2 {
+		t.Fatalf("images of another repo with token: got %v", rw.Code)
+	}
+
+	l := images(t, router, "/v1/repositories/user1/app/images")
+
+	if len(l) != 2 || l[0].Id != "abc" || l[1].Id != "def" || l[0].Pusher != "user1" {
+		t.Fatalf("images %+v", l)
+	}
+
+	for _, c := range []struct {
+		request
+		status int
+	}{
+		{request{method: "PUT", path: "/v1/repositories/user2/app/", username: "user1", password: "pass1", body: "[]"}, http.StatusForbidden},
+		{request{method: "PUT", path: "/v1/repositories/user1/app/", username: "user1", password: "wrong", body: "[]"}, http.StatusForbidden},
+		{request{method: "GET", path: "/v1/repositories/user1/app/images"}, http.StatusUnauthorized},
+		{request{method: "DELETE", path: "/v1/repositories/user1/app", username: "user1", password: "pass1"}, http.StatusNoContent},
+		{request{method: "DELETE", path: "/v1/repositories/user1/app", username: "user1", password: "pass1"}, http.StatusNotFound},
+	} {
+		if rw := c.do(router); rw.Code != c.status {
+			t.Errorf("%v %v as %v/%v: got %v, want %v", c.method, c.path, c.username, c.password, rw.Code, c.status)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+
+	idx := mem.New()
+
+	if err := idx.UpdateIndexImages("user1", "app", []index.Image{{Id: "abc"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	router, h := newRouter(t, idx)
+
+	if l := images(t, router, "/v1/repositories/user1/app/images"); len(l) != 1 {
+		t.Fatalf("before update: %+v", l)
+	}
+
+	h.Update(&RunningContext{
+		RunningContext: handler.RunningContext{
+			TokenAuth: newTokenAuth(t),
+			Acl:       users{"user1": "pass1"},
+		},
+		Index: mem.New(),
+	})
+
+	if l := images(t, router, "/v1/repositories/user1/app/images"); len(l) != 0 {
+		t.Fatalf("old index after update: %+v", l)
+	}
+}
@@ -8,12 +8,18 @@ import (
 	"net/http"
 	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gocraft/web"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/tg123/docker-wicket/handler"
 
 	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/audit"
+	"github.com/tg123/docker-wicket/metrics"
+	"github.com/tg123/docker-wicket/tracing"
 )
 
 type RunningContext struct {
@@ -29,9 +35,19 @@ type context struct {
 	permsWant []string
 
 	authReq handler.AuthRequest
+
+	// for audit
+	cred     *acl.Credential
+	identity acl.Username
+	denied   []string
+
+	rc *RunningContext
 }
 
-var runningContext *RunningContext
+// Handler serves requests with its RunningContext, which can be swapped by Update
+type Handler struct {
+	rc atomic.Pointer[RunningContext]
+}
 
 // TODO check docker sourse code
 var accessMap = map[string]acl.Permission{
@@ -48,6 +64,9 @@ func (c *context) commonHeader(rw web.ResponseWriter, req *web.Request, next web
 
 func (c *context) parseRequest(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
 
+	span := tracing.Span(req, "v2.parseRequest")
+	defer span.End()
+
 	// GET /v2/token/?service=registry.docker.com&scope=repository:samalba/my-app:push&account=jlhawn HTTP/1.1
 	c.authReq.Account = req.FormValue("account")
 
@@ -58,7 +77,9 @@ func (c *context) parseRequest(rw web.ResponseWriter, req *web.Request, next web
 	if scope != "" {
 		parts := strings.Split(scope, ":")
 		if len(parts) != 3 {
-			http.Error(rw, fmt.Sprintf("invalid scope: %q", scope), http.StatusBadRequest)
+			err := fmt.Errorf("invalid scope: %q", scope)
+			tracing.Fail(span, err)
+			http.Error(rw, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -78,35 +99,57 @@ func (c *context) parseRequest(rw web.ResponseWriter, req *web.Request, next web
 		c.permsWant = strings.Split(parts[2], ",")
 	}
 
+	span.SetAttributes(
+		attribute.String("wicket.scope.type", c.authReq.Type),
+		attribute.String("wicket.scope.name", c.authReq.Name),
+		attribute.StringSlice("wicket.scope.actions", c.permsWant),
+	)
+
 	next(rw, req)
 }
 
 func (c *context) authAccess(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
-	username, password, ok := req.BasicAuth()
 
-	if c.authReq.Account != "" && c.authReq.Account != username {
+	span := tracing.Span(req, "v2.authAccess")
+	defer span.End()
+
+	// client certificate or Authorization: Basic
+	cred := c.rc.Credential(req.Request)
+
+	span.SetAttributes(attribute.String("wicket.username", string(cred.Username)))
+
+	if c.authReq.Account != "" && acl.Username(c.authReq.Account) != cred.Username {
+		e := c.rc.AuditEvent(req.Request, "v2", audit.Login, cred)
+		e.Result = audit.Failure
+		e.Error = "account is not same as login user"
+		c.rc.Audit.Log(e)
+
 		http.Error(rw, "account is not same as login user", http.StatusForbidden)
 		return
 	}
 
-	var _username acl.Username
+	start := time.Now()
 
-	if ok {
-		_username = acl.Username(username)
-	} else {
-		_username = acl.Anonymous
-	}
+	_, login := tracing.Start(req.Context(), "acl.Login")
+
+	session, err := acl.Login(c.rc.Acl, cred)
+
+	login.SetAttributes(attribute.Bool("wicket.acl.ok", session != nil))
+	tracing.End(login, err)
 
-	ok, err := runningContext.Acl.CanLogin(_username, acl.Password(password))
+	c.Logger().Debug("acl login", "username", cred.Username, "ok", session != nil, "duration", time.Since(start))
+
+	c.rc.AuditLogin(req.Request, "v2", cred, session, err)
 
 	if err != nil {
+		c.Logger().Error("acl login failed", "username", cred.Username, "err", err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if !ok {
+	if session == nil {
 
-		if _username == acl.Anonymous {
+		if cred.Username == acl.Anonymous {
 			http.Error(rw, "", http.StatusUnauthorized)
 		} else {
 			http.Error(rw, "", http.StatusForbidden)
@@ -120,32 +163,83 @@ func (c *context) authAccess(rw web.ResponseWriter, req *web.Request, next web.N
 
 		p := accessMap[v]
 
-		ok, err := runningContext.Acl.CanAccess(_username, c.namespace, c.repo, p)
+		start := time.Now()
+
+		_, access := tracing.Start(req.Context(), "acl.CanAccess",
+			attribute.String("wicket.namespace", c.namespace),
+			attribute.String("wicket.repo", c.repo),
+			attribute.String("wicket.action", v),
+		)
+
+		ok, err := session.CanAccess(c.namespace, c.repo, p)
+
+		access.SetAttributes(attribute.Bool("wicket.acl.ok", ok))
+		tracing.End(access, err)
+
+		c.Logger().Debug("acl access", "identity", session.Username(), "namespace", c.namespace, "repo", c.repo, "action", v, "ok", ok, "duration", time.Since(start))
 
 		if err != nil {
+			c.Logger().Error("acl access failed", "identity", session.Username(), "err", err)
 			http.Error(rw, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		if ok {
 			c.authReq.Actions = append(c.authReq.Actions, v)
+		} else {
+			c.denied = append(c.denied, v)
 		}
 	}
 
 	sort.Strings(c.authReq.Actions)
 
+	span.SetAttributes(
+		attribute.String("wicket.identity", string(session.Username())),
+		attribute.StringSlice("wicket.granted", c.authReq.Actions),
+	)
+
+	c.cred = cred
+	c.identity = session.Username()
+
 	next(rw, req)
 }
 
 func (c *context) writeToken(rw web.ResponseWriter, req *web.Request) {
 
-	token, err := runningContext.TokenAuth.CreateToken(&c.authReq)
+	span := tracing.Span(req, "v2.writeToken")
+	defer span.End()
+
+	token, jti, err := c.rc.TokenAuth.IssueToken(&c.authReq)
+
+	span.SetAttributes(attribute.String("wicket.token.id", jti))
+
+	e := c.rc.AuditEvent(req.Request, "v2", audit.Token, c.cred)
+	e.Identity = string(c.identity)
+	e.ScopeType = c.authReq.Type
+	e.ScopeName = c.authReq.Name
+	e.Granted = c.authReq.Actions
+	e.Denied = c.denied
+	e.TokenID = jti
 
 	if err != nil {
+		e.Result = audit.Error
+		e.Error = err.Error()
+		c.rc.Audit.Log(e)
+
+		tracing.Fail(span, err)
+
+		c.Logger().Error("cannot create token", "err", err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	e.Result = audit.Success
+	c.rc.Audit.Log(e)
+
+	c.Logger().Debug("token issued", "identity", c.identity, "scope", c.authReq.Name, "actions", c.authReq.Actions, "jti", jti)
+
+	metrics.TokenIssued("v2", c.authReq.Actions)
+
 	rw.Header().Set("Content-Type", "application/json")
 
 	result, err := json.Marshal(&map[string]string{"token": token})
@@ -158,17 +252,35 @@ func (c *context) writeToken(rw web.ResponseWriter, req *web.Request) {
 	rw.Write(result)
 }
 
-func InstallHandler(rootRouter *web.Router, rc *RunningContext) {
+// requests in flight keep the RunningContext they started with, its drivers bound to the request, say, to log its id
+func (h *Handler) loadRunningContext(c *context, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	rc := *h.rc.Load()
+	rc.RunningContext = rc.RunningContext.WithContext(req.Context())
+	c.rc = &rc
+
+	next(rw, req)
+}
+
+// Update swaps rc in for new requests, say, on reload
+func (h *Handler) Update(rc *RunningContext) {
+	h.rc.Store(rc)
+}
+
+func InstallHandler(rootRouter *web.Router, rc *RunningContext) *Handler {
 
-	runningContext = rc
+	h := &Handler{}
+	h.rc.Store(rc)
 
 	c := context{}
 
 	v2 := rootRouter.Subrouter(c, "/v2").
+		Middleware(h.loadRunningContext).
 		Middleware((*context).commonHeader)
 
 	v2.Subrouter(c, "/token").
 		Middleware((*context).parseRequest).
 		Middleware((*context).authAccess).
 		Get("/", (*context).writeToken)
+
+	return h
 }
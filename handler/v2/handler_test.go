@@ -0,0 +1,206 @@
+package v2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/handler"
+)
+
+// newTokenAuth signs with a self signed cert
+func newTokenAuth(t *testing.T) *handler.TokenAuth {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "wicket"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	certFile := filepath.Join(dir, "token.crt")
+	keyFile := filepath.Join(dir, "token.key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ta := &handler.TokenAuth{Issuer: "wicket", Service: "registry", Expiration: 600}
+
+	if err := ta.LoadCertAndKey(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	return ta
+}
+
+// users log in with their password and own the namespace of their name
+type users map[acl.Username]acl.Password
+
+func (u users) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	p, ok := u[username]
+	return ok && p == password, nil
+}
+
+func (u users) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	return username != acl.Anonymous && string(username) == namespace, nil
+}
+
+func get(h http.Handler, path, username, password string) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest("GET", path, nil)
+
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+
+	return rw
+}
+
+// granted returns actions in the token of rw
+func granted(t *testing.T, ta *handler.TokenAuth, rw *httptest.ResponseRecorder) []string {
+
+	var body struct {
+		Token string `json:"token"`
+	}
+
+	if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	var actions []string
+
+	err := ta.Verify(body.Token, func(access handler.ResourceActions) error {
+		for _, a := range access {
+			actions = append(actions, a.Actions...)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return actions
+}
+
+func TestToken(t *testing.T) {
+
+	ta := newTokenAuth(t)
+
+	router := web.New(handler.ShareWebContext{})
+
+	InstallHandler(router, &RunningContext{handler.RunningContext{
+		TokenAuth: ta,
+		Acl:       users{"user1": "pass1"},
+	}})
+
+	for _, c := range []struct {
+		scope    string
+		username string
+		password string
+		status   int
+		actions  string
+	}{
+		{"repository:user1/app:pull,push", "user1", "pass1", http.StatusOK, "pull,push"},
+
+		// not what user1 can, the token has no access
+		{"repository:user2/app:pull", "user1", "pass1", http.StatusOK, ""},
+		{"repository:app:pull", "user1", "pass1", http.StatusOK, ""},
+
+		{"repository:user1/app:pull", "user1", "wrong", http.StatusForbidden, ""},
+		{"repository:user1/app:pull", "", "", http.StatusUnauthorized, ""},
+		{"user1/app", "user1", "pass1", http.StatusBadRequest, ""},
+	} {
+		rw := get(router, "/v2/token/?service=registry&scope="+c.scope, c.username, c.password)
+
+		if rw.Code != c.status {
+			t.Errorf("%v as %v/%v: got %v, want %v", c.scope, c.username, c.password, rw.Code, c.status)
+			continue
+		}
+
+		if rw.Code != http.StatusOK {
+			continue
+		}
+
+		if got := strings.Join(granted(t, ta, rw), ","); got != c.actions {
+			t.Errorf("%v as %v: granted %q, want %q", c.scope, c.username, got, c.actions)
+		}
+	}
+
+	// account must be who logs in
+	if rw := get(router, "/v2/token/?service=registry&account=user2&scope=repository:user1/app:pull", "user1", "pass1"); rw.Code != http.StatusForbidden {
+		t.Fatalf("account of another: got %v", rw.Code)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+
+	ta := newTokenAuth(t)
+
+	router := web.New(handler.ShareWebContext{})
+
+	h := InstallHandler(router, &RunningContext{handler.RunningContext{
+		TokenAuth: ta,
+		Acl:       users{"user1": "pass1"},
+	}})
+
+	path := "/v2/token/?service=registry&scope=repository:user1/app:pull"
+
+	if rw := get(router, path, "user1", "pass1"); rw.Code != http.StatusOK {
+		t.Fatalf("before update: got %v", rw.Code)
+	}
+
+	h.Update(&RunningContext{handler.RunningContext{
+		TokenAuth: ta,
+		Acl:       users{"user1": "changed"},
+	}})
+
+	if rw := get(router, path, "user1", "pass1"); rw.Code != http.StatusForbidden {
+		t.Fatalf("old password after update: got %v", rw.Code)
+	}
+
+	if rw := get(router, path, "user1", "changed"); rw.Code != http.StatusOK {
+		t.Fatalf("new password after update: got %v", rw.Code)
+	}
+}
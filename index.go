@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tg123/docker-wicket/index"
+)
+
+const indexUsage = `Usage: docker-wicket [OPTIONS] index COMMAND
+
+Commands:
+  backup FILE    write a copy of the index storage to FILE, - for stdout
+  export         print all repos and images as json lines
+  compact        shrink the index storage
+
+Drivers holding a lock on their storage, say, bolt, need wicket stopped,
+the command fails if the storage is locked, GET /api/index/backup of the
+running wicket needs no stop.
+`
+
+func indexCommand(args []string) error {
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, indexUsage)
+		return fmt.Errorf("index: missing command")
+	}
+
+	if indexDriverName == "" {
+		return fmt.Errorf("index: --v1_index_driver not set")
+	}
+
+	d, err := loadIndex()
+	if err != nil {
+		return fmt.Errorf("cannot load index driver %v: %v", indexDriverName, err)
+	}
+
+	defer index.Close(d)
+
+	switch args[0] {
+	case "backup":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, indexUsage)
+			return fmt.Errorf("index: backup needs a file")
+		}
+
+		b, ok := d.(index.Backuper)
+		if !ok {
+			return fmt.Errorf("index: driver %v cannot backup", indexDriverName)
+		}
+
+		return indexBackup(b, args[1])
+
+	case "export":
+		e, ok := d.(index.Exporter)
+		if !ok {
+			return fmt.Errorf("index: driver %v cannot export", indexDriverName)
+		}
+
+		return e.Export(os.Stdout)
+
+	case "compact":
+		c, ok := d.(index.Compacter)
+		if !ok {
+			return fmt.Errorf("index: driver %v has nothing to compact", indexDriverName)
+		}
+
+		return c.Compact()
+	}
+
+	fmt.Fprint(os.Stderr, indexUsage)
+	return fmt.Errorf("index: unknown command %v", args[0])
+}
+
+func indexBackup(b index.Backuper, file string) error {
+
+	if file == "-" {
+		return b.Backup(os.Stdout)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Backup(f); err != nil {
+		f.Close()
+		os.Remove(file)
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,394 @@
+// Package bolt stores the index in a bbolt file, for single node deployments without a database
+package bolt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tg123/docker-wicket/driver"
+	"github.com/tg123/docker-wicket/index"
+)
+
+var (
+	// namespace/repo -> repository
+	reposBucket = []byte("repositories")
+	// namespace/repo -> []index.Image
+	imagesBucket = []byte("images")
+)
+
+type repository struct {
+	CreatedAt int64 `json:"created_at"`
+}
+
+// a file can be opened once in a process, or Open waits for the lock forever,
+// drivers of the same path, say, the old and new one during reload, share it
+type shared struct {
+	path string
+
+	// Lock while swapping db in Compact
+	mu   sync.RWMutex
+	db   *bolt.DB
+	refs int
+}
+
+var (
+	openedMu sync.Mutex
+	opened   = make(map[string]*shared)
+)
+
+type Driver struct {
+	s *shared
+
+	closeOnce sync.Once
+}
+
+func init() {
+	index.Register("bolt", []driver.Option{
+		{Name: "path", Usage: "Path to the bolt file, created if not there"},
+		{Name: "timeout", Usage: "How long to wait for the file lock held by another process", Default: "1s"},
+	}, func(c driver.Config) (index.Driver, error) {
+		timeout, err := c.Duration("timeout")
+		if err != nil {
+			return nil, err
+		}
+
+		return Open(c.String("path"), timeout)
+	})
+}
+
+func openDB(path string, timeout time.Duration) (*bolt.DB, error) {
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%v is locked, is another wicket running on it?", path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{reposBucket, imagesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Open opens path, or shares it if opened already
+func Open(path string, timeout time.Duration) (*Driver, error) {
+
+	if path == "" {
+		return nil, fmt.Errorf("path to bolt file not set")
+	}
+
+	openedMu.Lock()
+	defer openedMu.Unlock()
+
+	s, ok := opened[path]
+
+	if !ok {
+		db, err := openDB(path, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		s = &shared{path: path, db: db}
+		opened[path] = s
+	}
+
+	s.refs++
+
+	return &Driver{s: s}, nil
+}
+
+func (d *Driver) Close() (err error) {
+
+	d.closeOnce.Do(func() {
+		openedMu.Lock()
+		defer openedMu.Unlock()
+
+		d.s.refs--
+
+		if d.s.refs == 0 {
+			delete(opened, d.s.path)
+			err = d.s.db.Close()
+		}
+	})
+
+	return
+}
+
+func (d *Driver) view(f func(tx *bolt.Tx) error) error {
+	d.s.mu.RLock()
+	defer d.s.mu.RUnlock()
+
+	return d.s.db.View(f)
+}
+
+// update runs f in a write transaction, which bolt runs one at a time
+func (d *Driver) update(f func(tx *bolt.Tx) error) error {
+	d.s.mu.RLock()
+	defer d.s.mu.RUnlock()
+
+	return d.s.db.Update(f)
+}
+
+func key(namespace, repo string) []byte {
+	return []byte(namespace + "/" + repo)
+}
+
+func (d *Driver) Check() error {
+	return d.view(func(tx *bolt.Tx) error {
+		if tx.Bucket(imagesBucket) == nil {
+			return fmt.Errorf("bucket %s not found", imagesBucket)
+		}
+
+		return nil
+	})
+}
+
+func (d *Driver) GetIndexImages(namespace, repo string) ([]index.Image, error) {
+
+	m := make([]index.Image, 0)
+
+	err := d.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(imagesBucket).Get(key(namespace, repo))
+
+		if b == nil {
+			return nil
+		}
+
+		return json.Unmarshal(b, &m)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func createRepo(tx *bolt.Tx, k []byte) error {
+
+	repos := tx.Bucket(reposBucket)
+
+	if repos.Get(k) != nil {
+		return nil
+	}
+
+	b, err := json.Marshal(&repository{CreatedAt: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	return repos.Put(k, b)
+}
+
+func (d *Driver) UpdateIndexImages(namespace, repo string, images []index.Image) error {
+
+	b, err := json.Marshal(images)
+	if err != nil {
+		return err
+	}
+
+	k := key(namespace, repo)
+
+	return d.update(func(tx *bolt.Tx) error {
+		if err := createRepo(tx, k); err != nil {
+			return err
+		}
+
+		return tx.Bucket(imagesBucket).Put(k, b)
+	})
+}
+
+func (d *Driver) UpdateIndex(namespace, repo string, f index.UpdateFunc) error {
+
+	k := key(namespace, repo)
+
+	return d.update(func(tx *bolt.Tx) error {
+		images := make([]index.Image, 0)
+
+		if b := tx.Bucket(imagesBucket).Get(k); b != nil {
+			if err := json.Unmarshal(b, &images); err != nil {
+				return err
+			}
+		}
+
+		images, err := f(images)
+		if err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(images)
+		if err != nil {
+			return err
+		}
+
+		if err := createRepo(tx, k); err != nil {
+			return err
+		}
+
+		return tx.Bucket(imagesBucket).Put(k, b)
+	})
+}
+
+func (d *Driver) CreateRepo(namespace, repo string) error {
+	return d.update(func(tx *bolt.Tx) error {
+		return createRepo(tx, key(namespace, repo))
+	})
+}
+
+func (d *Driver) DeleteRepo(namespace, repo string) error {
+
+	k := key(namespace, repo)
+
+	return d.update(func(tx *bolt.Tx) error {
+		images, repos := tx.Bucket(imagesBucket), tx.Bucket(reposBucket)
+
+		if images.Get(k) == nil && repos.Get(k) == nil {
+			return index.ErrRepoNotFound
+		}
+
+		if err := images.Delete(k); err != nil {
+			return err
+		}
+
+		return repos.Delete(k)
+	})
+}
+
+func (d *Driver) Search(query string) ([]index.Repository, error) {
+
+	l := make([]index.Repository, 0)
+
+	err := d.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(reposBucket).ForEach(func(k, v []byte) error {
+			namespace, repo, _ := strings.Cut(string(k), "/")
+
+			if index.Match(query, namespace, repo) {
+				l = append(l, index.Repository{Namespace: namespace, Repo: repo})
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Backup writes a consistent copy of the bolt file, writes of d wait meanwhile
+// the file is locked by the process opening it, `index backup` needs wicket stopped, GET /api/index/backup does not
+func (d *Driver) Backup(w io.Writer) error {
+	return d.view(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+type exported struct {
+	Namespace string        `json:"namespace"`
+	Repo      string        `json:"repo"`
+	CreatedAt int64         `json:"created_at"`
+	Images    []index.Image `json:"images"`
+}
+
+// Export writes all repos as json lines
+func (d *Driver) Export(w io.Writer) error {
+
+	enc := json.NewEncoder(w)
+
+	return d.view(func(tx *bolt.Tx) error {
+		images := tx.Bucket(imagesBucket)
+
+		return tx.Bucket(reposBucket).ForEach(func(k, v []byte) error {
+			var r repository
+
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("bad repo %s: %v", k, err)
+			}
+
+			e := exported{CreatedAt: r.CreatedAt, Images: make([]index.Image, 0)}
+
+			if b := images.Get(k); b != nil {
+				if err := json.Unmarshal(b, &e.Images); err != nil {
+					return fmt.Errorf("bad images of %s: %v", k, err)
+				}
+			}
+
+			// namespace has no /
+			e.Namespace, e.Repo, _ = strings.Cut(string(k), "/")
+
+			return enc.Encode(&e)
+		})
+	})
+}
+
+// Compact rewrites the file without free pages, reads and writes wait until done
+func (d *Driver) Compact() error {
+
+	s := d.s
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp := s.path + ".compact"
+
+	os.Remove(tmp)
+
+	dst, err := bolt.Open(tmp, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	// 64MB per tx
+	if err := bolt.Compact(dst, s.db, 64<<20); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := s.db.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// reopened either way, compacted or not
+	renameErr := os.Rename(tmp, s.path)
+
+	db, err := openDB(s.path, time.Second)
+	if err != nil {
+		return fmt.Errorf("cannot reopen %v after compaction: %v", s.path, err)
+	}
+
+	s.db = db
+
+	return renameErr
+}
@@ -0,0 +1,72 @@
+package bolt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/index/indextest"
+)
+
+func open(t *testing.T) *Driver {
+	d, err := Open(filepath.Join(t.TempDir(), "index.bolt"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { d.Close() })
+
+	return d
+}
+
+func TestConcurrentPushes(t *testing.T) {
+	indextest.ConcurrentPushes(t, open(t), 20, 5)
+}
+
+func TestBackup(t *testing.T) {
+
+	d := open(t)
+
+	if err := d.UpdateIndexImages("ns", "repo", []index.Image{{Id: "a", Checksum: "sha256:a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "backup.bolt")
+
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Backup(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the copy is a bolt file on its own
+	b, err := Open(file, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	images, err := b.GetIndexImages("ns", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 1 || images[0].Id != "a" {
+		t.Fatalf("got %+v from backup", images)
+	}
+
+	// the file is locked while d has it, as it is for another process
+	if _, err := openDB(d.s.path, 100*time.Millisecond); err == nil {
+		t.Fatal("opened a locked file")
+	}
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/index/indextest"
+)
+
+func openSQLite(t *testing.T) *Driver {
+	d, err := Open("sqlite", filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { d.Close() })
+
+	return d
+}
+
+func TestConcurrentPushes(t *testing.T) {
+	indextest.ConcurrentPushes(t, openSQLite(t), 20, 5)
+}
+
+func TestRepoLifecycle(t *testing.T) {
+	indextest.RepoLifecycle(t, openSQLite(t))
+}
+
+func TestReopen(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "index.db")
+
+	d, err := Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.UpdateIndexImages("library", "test", []index.Image{{Id: "a", Checksum: "sha256:a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Close()
+
+	d, err = Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer d.Close()
+
+	images, err := d.GetIndexImages("library", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 1 || images[0].Id != "a" || images[0].Checksum != "sha256:a" {
+		t.Fatalf("images after reopen: %v", images)
+	}
+}
+
+// TestMigrate opens a database made before images had push time, pusher and tags, and one made before schema_version
+func TestMigrate(t *testing.T) {
+
+	for name, images := range map[string]string{
+		"first": `CREATE TABLE images (
+			namespace VARCHAR(255) NOT NULL,
+			repo      VARCHAR(255) NOT NULL,
+			seq       INTEGER      NOT NULL,
+			id        VARCHAR(255) NOT NULL,
+			checksum  VARCHAR(255) NOT NULL,
+			PRIMARY KEY (namespace, repo, id)
+		)`,
+		"unversioned": `CREATE TABLE images (
+			namespace VARCHAR(255) NOT NULL,
+			repo      VARCHAR(255) NOT NULL,
+			seq       INTEGER      NOT NULL,
+			id        VARCHAR(255) NOT NULL,
+			checksum  VARCHAR(255) NOT NULL,
+			pushed_at BIGINT       NOT NULL DEFAULT 0,
+			pusher    VARCHAR(255) NOT NULL DEFAULT '',
+			tags      TEXT         NOT NULL DEFAULT '',
+			PRIMARY KEY (namespace, repo, id)
+		)`,
+	} {
+		dsn := filepath.Join(t.TempDir(), "index.db")
+
+		db, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, s := range []string{
+			`CREATE TABLE repositories (
+				namespace  VARCHAR(255) NOT NULL,
+				repo       VARCHAR(255) NOT NULL,
+				created_at BIGINT       NOT NULL,
+				PRIMARY KEY (namespace, repo)
+			)`,
+			images,
+			`INSERT INTO repositories VALUES ('library', 'test', 0)`,
+			`INSERT INTO images (namespace, repo, seq, id, checksum) VALUES ('library', 'test', 0, 'a', 'sha256:a')`,
+		} {
+			if _, err := db.Exec(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		db.Close()
+
+		// twice, the second finds it up to date
+		for range 2 {
+			d, err := Open("sqlite", dsn)
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+
+			old, err := d.GetIndexImages("library", "test")
+			if err != nil || len(old) != 1 || old[0].Id != "a" {
+				t.Fatalf("%v: images kept %v: %v", name, old, err)
+			}
+
+			pushed := index.Image{Id: "b", Checksum: "sha256:b", PushedAt: 1, Pusher: "alice", Tags: []string{"latest"}}
+
+			if err := d.UpdateIndexImages("library", "test", []index.Image{old[0], pushed}); err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+
+			got, err := d.GetIndexImages("library", "test")
+			if err != nil || len(got) != 2 || got[1].Pusher != "alice" || got[1].PushedAt != 1 || len(got[1].Tags) != 1 {
+				t.Fatalf("%v: images after push %v: %v", name, got, err)
+			}
+
+			if err := d.UpdateIndexImages("library", "test", old); err != nil {
+				t.Fatal(err)
+			}
+
+			var version int
+
+			if err := d.db.QueryRow(`SELECT version FROM schema_version`).Scan(&version); err != nil || version != len(migrations) {
+				t.Fatalf("%v: schema version %v: %v", name, version, err)
+			}
+
+			d.Close()
+		}
+	}
+}
@@ -1,46 +1,127 @@
 package index
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/tg123/docker-wicket/driver"
 )
 
-type Image struct {
-	Id       string `json:"id"`
-	Checksum string `json:"checksum,omitempty"`
-}
+// ErrRepoNotFound is returned by DeleteRepo of a repo never created nor pushed
+var ErrRepoNotFound = errors.New("repo not found")
+
+// UpdateFunc gets the stored images of a repo and returns what to store instead
+type UpdateFunc func(images []Image) ([]Image, error)
 
 type Driver interface {
 	GetIndexImages(namespace, repo string) ([]Image, error)
 
+	// UpdateIndexImages replaces images of a repo
 	UpdateIndexImages(namespace, repo string, images []Image) error
 
+	// UpdateIndex stores what f returns, no other update of the repo runs between reading and storing,
+	// so that concurrent pushes do not lose images, nothing is stored if f fails
+	UpdateIndex(namespace, repo string, f UpdateFunc) error
+
+	// CreateRepo records the repo, no-op if it is there
 	CreateRepo(namespace, repo string) error
 
+	// DeleteRepo removes the repo and its images, ErrRepoNotFound if it is not there
 	DeleteRepo(namespace, repo string) error
+
+	// Search lists repos matching query, see Match
+	Search(query string) ([]Repository, error)
 }
 
-type managedDriver struct {
-	driver Driver
-	check  func() error
+// Close releases what d holds, say, db pools, if d is an io.Closer
+// drivers need not implement Close
+func Close(d Driver) error {
+	if c, ok := d.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
 }
 
-var drivers = make(map[string]managedDriver)
+// ContextBinder is implemented by drivers which use the context of the request they serve, say, to log its request id
+type ContextBinder interface {
+	// WithContext returns the driver serving the request of ctx
+	WithContext(ctx context.Context) Driver
+}
 
-func Load(name string) (Driver, error) {
+// WithContext returns d serving the request of ctx, d itself if it is not a ContextBinder
+func WithContext(d Driver, ctx context.Context) Driver {
+	if b, ok := d.(ContextBinder); ok {
+		return b.WithContext(ctx)
+	}
+
+	return d
+}
 
-	d, ok := drivers[name]
+// Checker is implemented by drivers which can tell if they work, say, db reachable
+type Checker interface {
+	Check() error
+}
 
-	if !ok {
-		return nil, fmt.Errorf("Driver not found")
+// Check runs d's self check, nil if d is not a Checker
+func Check(d Driver) error {
+	if c, ok := d.(Checker); ok {
+		return c.Check()
 	}
 
-	if err := d.check(); err != nil {
-		return nil, err
+	return nil
+}
+
+// Backuper is implemented by drivers which can write a consistent copy of their storage
+type Backuper interface {
+	Backup(w io.Writer) error
+}
+
+// ErrCannotBackup is returned by Backup of a driver which is not a Backuper
+var ErrCannotBackup = errors.New("driver cannot backup")
+
+// Backup writes a consistent copy of d's storage to w, ErrCannotBackup if d is not a Backuper
+func Backup(d Driver, w io.Writer) error {
+	if b, ok := d.(Backuper); ok {
+		return b.Backup(w)
 	}
 
-	return d.driver, nil
+	return ErrCannotBackup
+}
+
+// Exporter is implemented by drivers which can dump all repos and images as json
+type Exporter interface {
+	Export(w io.Writer) error
+}
+
+// Compacter is implemented by drivers whose storage grows with deletes, say, bolt
+type Compacter interface {
+	Compact() error
+}
+
+// Factory creates a driver from settings of one instance, a fresh one on each call,
+// so that a driver can be rebuilt on reload while the old one still serves,
+// or used many times with different settings
+type Factory func(c driver.Config) (Driver, error)
+
+var drivers driver.Registry[Driver]
+
+// Load creates an instance of driver name, options not in c are their defaults
+func Load(name string, c driver.Config) (Driver, error) {
+	return drivers.New(name, c)
+}
+
+// Drivers lists names of registered drivers
+func Drivers() []string {
+	return drivers.Names()
+}
+
+// Options lists what driver name takes, false if not registered
+func Options(name string) ([]driver.Option, bool) {
+	return drivers.Options(name)
 }
 
-func Register(name string, driver Driver, check func() error) {
-	drivers[name] = managedDriver{driver, check}
+func Register(name string, options []driver.Option, factory Factory) {
+	drivers.Register(name, options, factory)
 }
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 
-	"github.com/docker/docker/pkg/mflag"
+	"github.com/tg123/docker-wicket/atomicfile"
+	"github.com/tg123/docker-wicket/driver"
 	"github.com/tg123/docker-wicket/index"
 )
 
@@ -14,21 +17,37 @@ type Driver struct {
 	Path string
 }
 
-func init() {
-
-	d := &Driver{}
+// by index file, shared by drivers of the same path, say, the old and new one during reload
+var locks index.RepoLocks
 
-	mflag.StringVar(&d.Path, []string{"-v1_index_file_path"}, "", "Path to v1 repo")
+func init() {
+	index.Register("v1file", []driver.Option{
+		{Name: "path", Usage: "Path to v1 repo"},
+	}, func(c driver.Config) (index.Driver, error) {
+		path := c.String("path")
 
-	index.Register("v1file", d, func() error {
-		if d.Path == "" {
-			return fmt.Errorf("path to v1 repo not set")
+		if path == "" {
+			return nil, fmt.Errorf("path to v1 repo not set")
 		}
 
-		return nil
+		return &Driver{Path: path}, nil
 	})
 }
 
+func (d *Driver) Check() error {
+	fi, err := os.Stat(d.Path)
+
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("%v is not a directory", d.Path)
+	}
+
+	return nil
+}
+
 func (d *Driver) repoPath(namespace, repo string) string {
 	return fmt.Sprintf("%v/repositories/%v/%v", d.Path, namespace, repo)
 }
@@ -37,6 +56,15 @@ func (d *Driver) indexFile(namespace, repo string) string {
 	return fmt.Sprintf("%v/_index_images", d.repoPath(namespace, repo))
 }
 
+// when the repo was created, wicket's own, docker-registry does not know it
+func (d *Driver) repoFile(namespace, repo string) string {
+	return fmt.Sprintf("%v/_index_repository", d.repoPath(namespace, repo))
+}
+
+type repository struct {
+	CreatedAt int64 `json:"created_at"`
+}
+
 func (d *Driver) GetIndexImages(namespace, repo string) ([]index.Image, error) {
 
 	// repositories/library/test/_index_images
@@ -81,13 +109,110 @@ func (d *Driver) UpdateIndexImages(namespace, repo string, images []index.Image)
 
 	f := d.indexFile(namespace, repo)
 
-	return ioutil.WriteFile(f, b, 0644)
+	// readers never see a half written file
+	return atomicfile.WriteFile(f, b, 0644)
+}
+
+func (d *Driver) UpdateIndex(namespace, repo string, f index.UpdateFunc) error {
+	return locks.Update(d, d.indexFile(namespace, repo), namespace, repo, f)
 }
 
 func (d *Driver) CreateRepo(namespace, repo string) error {
-	return nil
+
+	unlock := locks.Lock(d.indexFile(namespace, repo))
+	defer unlock()
+
+	f := d.repoFile(namespace, repo)
+
+	if _, err := os.Stat(f); err == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(&repository{CreatedAt: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(d.repoPath(namespace, repo), 0755); err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(f, b, 0644)
 }
 
+// DeleteRepo removes what wicket wrote, and the dirs of the repo and namespace if nothing else is left
 func (d *Driver) DeleteRepo(namespace, repo string) error {
+
+	unlock := locks.Lock(d.indexFile(namespace, repo))
+	defer unlock()
+
+	found := false
+
+	for _, f := range []string{d.indexFile(namespace, repo), d.repoFile(namespace, repo)} {
+		err := os.Remove(f)
+
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		found = true
+	}
+
+	if !found {
+		return index.ErrRepoNotFound
+	}
+
+	// fail if not empty, say, tags of docker-registry
+	p := d.repoPath(namespace, repo)
+
+	if os.Remove(p) == nil {
+		os.Remove(filepath.Dir(p))
+	}
+
 	return nil
 }
+
+// Search lists dirs of repositories/<namespace>/<repo> with files of wicket in them
+func (d *Driver) Search(query string) ([]index.Repository, error) {
+
+	l := make([]index.Repository, 0)
+
+	namespaces, err := os.ReadDir(d.Path + "/repositories")
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ns := range namespaces {
+		if !ns.IsDir() {
+			continue
+		}
+
+		repos, err := os.ReadDir(filepath.Join(d.Path, "repositories", ns.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range repos {
+			if !r.IsDir() || !index.Match(query, ns.Name(), r.Name()) {
+				continue
+			}
+
+			for _, f := range []string{d.indexFile(ns.Name(), r.Name()), d.repoFile(ns.Name(), r.Name())} {
+				if _, err := os.Stat(f); err == nil {
+					l = append(l, index.Repository{Namespace: ns.Name(), Repo: r.Name()})
+					break
+				}
+			}
+		}
+	}
+
+	return l, nil
+}
@@ -0,0 +1,11 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/tg123/docker-wicket/index/indextest"
+)
+
+func TestConcurrentPushes(t *testing.T) {
+	indextest.ConcurrentPushes(t, &Driver{Path: t.TempDir()}, 20, 5)
+}
@@ -0,0 +1,74 @@
+package index
+
+import (
+	"time"
+)
+
+// Image is an entry of _index_images, id and checksum are what docker-registry stores,
+// the rest are kept by wicket and ignored by docker and docker-registry
+type Image struct {
+	Id       string `json:"id"`
+	Checksum string `json:"checksum,omitempty"`
+
+	// docker sends the tag of tagged images it pushes, not stored, see Tags
+	Tag string `json:"Tag,omitempty"`
+
+	// unix time of the push which added the image, and who pushed it
+	PushedAt int64  `json:"pushed_at,omitempty"`
+	Pusher   string `json:"pusher,omitempty"`
+
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Merge adds pushed to images in order, images keep their order and new ones go last,
+// a tag pushed with an image is moved to it from others
+func Merge(images, pushed []Image, pusher string, now time.Time) []Image {
+
+	merged := make([]Image, 0, len(images)+len(pushed))
+	pos := make(map[string]int)
+
+	for _, i := range images {
+		pos[i.Id] = len(merged)
+		merged = append(merged, i)
+	}
+
+	for _, p := range pushed {
+		n, ok := pos[p.Id]
+
+		if !ok {
+			n = len(merged)
+			pos[p.Id] = n
+			merged = append(merged, Image{Id: p.Id, PushedAt: now.Unix(), Pusher: pusher})
+		}
+
+		if merged[n].Checksum == "" {
+			merged[n].Checksum = p.Checksum
+		}
+
+		if p.Tag != "" {
+			for j := range merged {
+				merged[j].Tags = removeTag(merged[j].Tags, p.Tag)
+			}
+
+			merged[n].Tags = append(merged[n].Tags, p.Tag)
+		}
+	}
+
+	return merged
+}
+
+func removeTag(tags []string, tag string) []string {
+	l := tags[:0:0]
+
+	for _, t := range tags {
+		if t != tag {
+			l = append(l, t)
+		}
+	}
+
+	if len(l) == 0 {
+		return nil
+	}
+
+	return l
+}
@@ -0,0 +1,128 @@
+// Package indextest has checks every index.Driver should pass, for tests of drivers
+package indextest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tg123/docker-wicket/index"
+)
+
+// ConcurrentPushes runs pushes goroutines, each adding its own images to the same repo with UpdateIndex,
+// and fails t unless all images are there after
+func ConcurrentPushes(t *testing.T, d index.Driver, pushes, images int) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, pushes)
+
+	for p := 0; p < pushes; p++ {
+		wg.Add(1)
+
+		go func(p int) {
+			defer wg.Done()
+
+			pushed := make([]index.Image, images)
+
+			for i := range pushed {
+				pushed[i] = index.Image{Id: fmt.Sprintf("%v-%v", p, i), Checksum: fmt.Sprintf("sha256:%v-%v", p, i)}
+			}
+
+			errs <- d.UpdateIndex("library", "test", func(stored []index.Image) ([]index.Image, error) {
+				return index.Merge(stored, pushed, fmt.Sprintf("user%v", p), time.Now()), nil
+			})
+		}(p)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("UpdateIndex: %v", err)
+		}
+	}
+
+	stored, err := d.GetIndexImages("library", "test")
+	if err != nil {
+		t.Fatalf("GetIndexImages: %v", err)
+	}
+
+	if len(stored) != pushes*images {
+		t.Fatalf("%v images stored, want %v", len(stored), pushes*images)
+	}
+
+	seen := make(map[string]bool)
+
+	for _, i := range stored {
+		if seen[i.Id] {
+			t.Fatalf("image %v stored twice", i.Id)
+		}
+
+		seen[i.Id] = true
+	}
+
+	for p := 0; p < pushes; p++ {
+		for i := 0; i < images; i++ {
+			if id := fmt.Sprintf("%v-%v", p, i); !seen[id] {
+				t.Errorf("image %v lost", id)
+			}
+		}
+	}
+}
+
+// RepoLifecycle checks CreateRepo, UpdateIndexImages, GetIndexImages and DeleteRepo of a fresh driver
+func RepoLifecycle(t *testing.T, d index.Driver) {
+	t.Helper()
+
+	if err := d.DeleteRepo("library", "none"); err != index.ErrRepoNotFound {
+		t.Fatalf("DeleteRepo of missing repo: %v, want ErrRepoNotFound", err)
+	}
+
+	if err := d.CreateRepo("library", "test"); err != nil {
+		t.Fatalf("CreateRepo: %v", err)
+	}
+
+	// no-op the second time
+	if err := d.CreateRepo("library", "test"); err != nil {
+		t.Fatalf("CreateRepo again: %v", err)
+	}
+
+	want := []index.Image{
+		{Id: "b", Checksum: "sha256:b", PushedAt: 100, Pusher: "user1", Tags: []string{"latest", "v1"}},
+		{Id: "a", Checksum: "sha256:a", PushedAt: 200, Pusher: "user2"},
+	}
+
+	if err := d.UpdateIndexImages("library", "test", want); err != nil {
+		t.Fatalf("UpdateIndexImages: %v", err)
+	}
+
+	got, err := d.GetIndexImages("library", "test")
+	if err != nil {
+		t.Fatalf("GetIndexImages: %v", err)
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("GetIndexImages: %v, want %v in order", got, want)
+	}
+
+	if err := d.DeleteRepo("library", "test"); err != nil {
+		t.Fatalf("DeleteRepo: %v", err)
+	}
+
+	got, err = d.GetIndexImages("library", "test")
+	if err != nil {
+		t.Fatalf("GetIndexImages after delete: %v", err)
+	}
+
+	if len(got) != 0 {
+		t.Fatalf("%v images after delete, want none", len(got))
+	}
+
+	if err := d.DeleteRepo("library", "test"); err != index.ErrRepoNotFound {
+		t.Fatalf("DeleteRepo again: %v, want ErrRepoNotFound", err)
+	}
+}
@@ -0,0 +1,74 @@
+package index
+
+import (
+	"sync"
+)
+
+// RepoLocks are mutexes by key, say, a repo, for drivers whose storage has no transactions
+// zero value is ready to use
+type RepoLocks struct {
+	mu    sync.Mutex
+	locks map[string]*repoLock
+}
+
+type repoLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks key and returns the unlock
+func (l *RepoLocks) Lock(key string) func() {
+
+	l.mu.Lock()
+
+	if l.locks == nil {
+		l.locks = make(map[string]*repoLock)
+	}
+
+	k, ok := l.locks[key]
+
+	if !ok {
+		k = &repoLock{}
+		l.locks[key] = k
+	}
+
+	// dropped by the last one unlocking
+	k.refs++
+
+	l.mu.Unlock()
+
+	k.Lock()
+
+	return func() {
+		k.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		k.refs--
+
+		if k.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// Update is UpdateIndex of d with GetIndexImages and UpdateIndexImages under lock key
+// only updates in this process are serialized
+func (l *RepoLocks) Update(d Driver, key, namespace, repo string, f UpdateFunc) error {
+
+	unlock := l.Lock(key)
+	defer unlock()
+
+	images, err := d.GetIndexImages(namespace, repo)
+	if err != nil {
+		return err
+	}
+
+	images, err = f(images)
+	if err != nil {
+		return err
+	}
+
+	return d.UpdateIndexImages(namespace, repo, images)
+}
@@ -2,50 +2,188 @@ package mem
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/robfig/go-cache"
+	"github.com/tg123/docker-wicket/driver"
 	"github.com/tg123/docker-wicket/index"
 )
 
 // only for dev purpose
 
+// images of drivers of the same name, shared so that the old and new driver during reload see the same,
+// gone when the last driver of the name is closed, or on restart
+type store struct {
+	name string
+
+	mu      sync.RWMutex
+	images  map[string][]index.Image
+	created map[string]time.Time
+
+	refs int
+}
+
+var (
+	openedMu sync.Mutex
+	opened   = make(map[string]*store)
+)
+
 type Driver struct {
+	s *store
+
+	closeOnce sync.Once
 }
 
-var mem = cache.New(0, 0) // just magic because this driver is only for dev
+func newStore(name string) *store {
+	return &store{
+		name:    name,
+		images:  make(map[string][]index.Image),
+		created: make(map[string]time.Time),
+	}
+}
+
+// New returns a driver with images of its own, not shared by name
+func New() *Driver {
+	return &Driver{s: newStore("")}
+}
+
+// Open returns a driver sharing images with other open drivers of name
+func Open(name string) *Driver {
+
+	openedMu.Lock()
+	defer openedMu.Unlock()
+
+	s, ok := opened[name]
+
+	if !ok {
+		s = newStore(name)
+		opened[name] = s
+	}
+
+	s.refs++
+
+	return &Driver{s: s}
+}
 
 func init() {
+	index.Register("mem", []driver.Option{
+		{Name: "name", Usage: "Drivers of the same name share images, say, across reload", Default: "default"},
+	}, func(c driver.Config) (index.Driver, error) {
+		return Open(c.String("name")), nil
+	})
+}
+
+func (d *Driver) Close() error {
 
-	d := &Driver{}
+	d.closeOnce.Do(func() {
+		if d.s.name == "" {
+			return
+		}
 
-	index.Register("mem", d, func() error { return nil })
+		openedMu.Lock()
+		defer openedMu.Unlock()
+
+		d.s.refs--
+
+		if d.s.refs == 0 {
+			delete(opened, d.s.name)
+		}
+	})
+
+	return nil
 }
 
 func key(namespace, repo string) string {
 	return fmt.Sprintf("%v/%v", namespace, repo)
 }
 
+// copied in and out, callers can not change what is stored
+func clone(images []index.Image) []index.Image {
+	return append(make([]index.Image, 0, len(images)), images...)
+}
+
 func (d *Driver) GetIndexImages(namespace, repo string) ([]index.Image, error) {
+	d.s.mu.RLock()
+	defer d.s.mu.RUnlock()
 
-	m, ok := mem.Get(key(namespace, repo))
+	return clone(d.s.images[key(namespace, repo)]), nil
+}
 
-	if !ok {
-		m = make([]index.Image, 0)
-	}
+func (d *Driver) UpdateIndexImages(namespace, repo string, images []index.Image) error {
+	d.s.mu.Lock()
+	defer d.s.mu.Unlock()
+
+	k := key(namespace, repo)
 
-	return m.([]index.Image), nil
+	d.createRepo(k)
+	d.s.images[k] = clone(images)
+
+	return nil
 }
 
-func (d *Driver) UpdateIndexImages(namespace, repo string, images []index.Image) error {
-	mem.Set(key(namespace, repo), images, -1)
+func (d *Driver) UpdateIndex(namespace, repo string, f index.UpdateFunc) error {
+	d.s.mu.Lock()
+	defer d.s.mu.Unlock()
+
+	k := key(namespace, repo)
+
+	images, err := f(clone(d.s.images[k]))
+	if err != nil {
+		return err
+	}
+
+	d.createRepo(k)
+	d.s.images[k] = clone(images)
 
 	return nil
 }
 
+// with d.s.mu held
+func (d *Driver) createRepo(k string) {
+	if _, ok := d.s.created[k]; !ok {
+		d.s.created[k] = time.Now()
+	}
+}
+
 func (d *Driver) CreateRepo(namespace, repo string) error {
+	d.s.mu.Lock()
+	defer d.s.mu.Unlock()
+
+	d.createRepo(key(namespace, repo))
+
 	return nil
 }
 
 func (d *Driver) DeleteRepo(namespace, repo string) error {
+	d.s.mu.Lock()
+	defer d.s.mu.Unlock()
+
+	k := key(namespace, repo)
+
+	if _, ok := d.s.created[k]; !ok {
+		return index.ErrRepoNotFound
+	}
+
+	delete(d.s.created, k)
+	delete(d.s.images, k)
+
 	return nil
 }
+
+func (d *Driver) Search(query string) ([]index.Repository, error) {
+	d.s.mu.RLock()
+	defer d.s.mu.RUnlock()
+
+	l := make([]index.Repository, 0)
+
+	for k := range d.s.created {
+		namespace, repo, _ := strings.Cut(k, "/")
+
+		if index.Match(query, namespace, repo) {
+			l = append(l, index.Repository{Namespace: namespace, Repo: repo})
+		}
+	}
+
+	return l, nil
+}
@@ -0,0 +1,49 @@
+package mem
+
+import (
+	"testing"
+
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/index/indextest"
+)
+
+func TestConcurrentPushes(t *testing.T) {
+	indextest.ConcurrentPushes(t, New(), 20, 5)
+}
+
+func TestOpenShares(t *testing.T) {
+
+	a := Open("shared")
+	b := Open("shared")
+
+	if err := a.CreateRepo("foo", "app"); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Close()
+
+	// closed twice counts once
+	a.Close()
+
+	if err := b.DeleteRepo("foo", "app"); err != nil {
+		t.Fatalf("repo of a not in b: %v", err)
+	}
+
+	if err := b.CreateRepo("foo", "app"); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Close()
+
+	// gone with the last driver of the name
+	c := Open("shared")
+	defer c.Close()
+
+	if err := c.DeleteRepo("foo", "app"); err != index.ErrRepoNotFound {
+		t.Fatalf("got %v, want %v", err, index.ErrRepoNotFound)
+	}
+
+	if err := New().DeleteRepo("foo", "app"); err != index.ErrRepoNotFound {
+		t.Fatalf("New shares: %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package index
+
+import (
+	"strings"
+)
+
+// Repository is a result of Search
+type Repository struct {
+	Namespace string `json:"namespace"`
+	Repo      string `json:"repo"`
+}
+
+func (r *Repository) Name() string {
+	return r.Namespace + "/" + r.Repo
+}
+
+// Match tells if namespace/repo contains query, case insensitive, empty query matches all
+func Match(query, namespace, repo string) bool {
+	return strings.Contains(strings.ToLower(namespace+"/"+repo), strings.ToLower(query))
+}
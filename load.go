@@ -4,6 +4,11 @@ import (
 	_ "github.com/tg123/docker-wicket/acl/derelict"
 	_ "github.com/tg123/docker-wicket/acl/htpasswd"
 	_ "github.com/tg123/docker-wicket/acl/interdict"
+	_ "github.com/tg123/docker-wicket/acl/kubernetes"
+	_ "github.com/tg123/docker-wicket/acl/oidc"
+	_ "github.com/tg123/docker-wicket/index/bolt"
+	_ "github.com/tg123/docker-wicket/index/db"
 	_ "github.com/tg123/docker-wicket/index/file"
 	_ "github.com/tg123/docker-wicket/index/mem"
+	_ "github.com/tg123/docker-wicket/plugin"
 )
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/tg123/docker-wicket/requestid"
+)
+
+var (
+	logLevel  string
+	logFormat string
+)
+
+func setupLogging() error {
+
+	var level slog.Level
+
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("bad log level %q, want debug, info, warn or error", logLevel)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+
+	switch strings.ToLower(logFormat) {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("bad log format %q, want text or json", logFormat)
+	}
+
+	// drivers log with the context of the request they serve
+	slog.SetDefault(slog.New(requestid.NewHandler(h)))
+
+	return nil
+}
+
+// fatal logs msg at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
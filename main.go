@@ -1,22 +1,25 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/docker/docker/pkg/mflag"
 	"github.com/gocraft/web"
-	"github.com/rakyll/globalconf"
 
-	"github.com/tg123/docker-wicket/acl"
-	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/audit"
+	"github.com/tg123/docker-wicket/config"
+	"github.com/tg123/docker-wicket/metrics"
+	"github.com/tg123/docker-wicket/tracing"
 
 	"github.com/tg123/docker-wicket/handler"
-	"github.com/tg123/docker-wicket/handler/v1"
-	"github.com/tg123/docker-wicket/handler/v2"
 )
 
 // parse conf from env and args
@@ -25,102 +28,344 @@ func parseConf() {
 	// let mflag parse first
 	mflag.Parse()
 
-	// using gconf parse env
-	gconf, err := globalconf.NewWithOptions(&globalconf.Options{
-		EnvPrefix: "WICKET_",
+	mflag.Visit(func(f *mflag.Flag) {
+		for _, n := range f.Names {
+			cmdlineFlags[strings.TrimPrefix(n, "-")] = true
+		}
 	})
 
-	if err != nil {
-		log.Fatalf("error parsing config file: %v", err)
-	}
-
-	fs := flag.NewFlagSet("", flag.ContinueOnError)
-
+	// env, say, WICKET_ACL_DRIVER, for flags not on command line
 	mflag.VisitAll(func(f *mflag.Flag) {
 		for _, n := range f.Names {
-			if len(n) < 2 {
+			n = strings.TrimPrefix(n, "-")
+
+			if len(n) < 2 || cmdlineFlags[n] {
 				continue
 			}
 
-			n = strings.TrimPrefix(n, "-")
-			fs.Var(f.Value, n, f.Usage)
+			if v, ok := os.LookupEnv(envName(n)); ok && v != "" {
+				if err := f.Value.Set(v); err != nil {
+					fatal("Cannot parse config", "env", envName(n), "err", err)
+				}
+			}
 		}
 	})
 
-	gconf.ParseSet("", fs)
+	if configFile == "" {
+		return
+	}
+
+	var err error
+
+	loadedConfig, err = config.Read(configFile)
+
+	if err == nil {
+		err = loadedConfig.Apply(setElsewhere)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad config file:\n%v\n", err)
+		os.Exit(1)
+	}
 }
 
-// TODO mmore log
-func main() {
+// flags on command line and env win over config file
+func setElsewhere(name string) bool {
+	return cmdlineFlags[name] || os.Getenv(envName(name)) != ""
+}
+
+func envName(flag string) string {
+	return strings.ToUpper("WICKET_" + strings.Replace(flag, "-", "_", -1))
+}
+
+var (
+	configFile   string
+	loadedConfig *config.File
+	cmdlineFlags = make(map[string]bool)
+
+	ListenAddr     string
+	Port           uint
+	trustedProxies string
+
+	tlsCertPath        string
+	tlsKeyPath         string
+	tlsMinVersion      string
+	tlsCiphers         string
+	tlsClientCAPath    string
+	tlsClientUsernames stringList
+
+	drainTimeout  time.Duration
+	shutdownDelay time.Duration
+
+	certExpiryWarning time.Duration
 
-	var ListenAddr string
-	var Port uint
+	auditSinks stringList
 
-	tokenAuth := &handler.TokenAuth{}
+	traceExporter string
+	traceEndpoint string
+	traceService  string
+
+	readiness = &handler.Readiness{}
+
+	tokenIssuer     string
+	tokenService    string
+	tokenExpiration int64
+
+	certPath    string
+	certKeyPath string
+
+	aclDriverName string
+
+	robotFile  string
+	tokenFile  string
+	adminUsers string
+
+	indexDriverName string
+	v1Endpoint      string
+)
+
+// sub commands, run with what is left after global flags parsed
+// e.g. docker-wicket --cert=wicket.crt token verify <token>
+var commands = map[string]func(args []string) error{
+	"run": func(args []string) error {
+		serve()
+		return nil
+	},
+	"token":   tokenCommand,
+	"config":  configCommand,
+	"robot":   robotCommand,
+	"drivers": driversCommand,
+	"index":   indexCommand,
+}
+
+func main() {
+
+	mflag.StringVar(&configFile, []string{"c", "-config"}, "", "YAML config file, flags and env win over it")
 
 	// http
 	mflag.StringVar(&ListenAddr, []string{"l", "-addr"}, "0.0.0.0", "Listening Address")
 	mflag.UintVar(&Port, []string{"p", "-port"}, 9999, "Listening Port")
+	mflag.StringVar(&trustedProxies, []string{"-trusted_proxies"}, "", "Comma separated CIDRs of proxies in front, whose X-Real-IP and X-Forwarded-For are believed")
+
+	// log
+	mflag.StringVar(&logLevel, []string{"-log_level"}, "info", "Log level, debug, info, warn or error")
+	mflag.StringVar(&logFormat, []string{"-log_format"}, "text", "Log format, text or json")
+
+	// tracing
+	mflag.StringVar(&traceExporter, []string{"-trace_exporter"}, "none", "Where spans go, none, stdout or otlp")
+	mflag.StringVar(&traceEndpoint, []string{"-trace_endpoint"}, "", "OTLP http endpoint, e.g. http://collector:4318, empty to use OTEL_EXPORTER_OTLP_ENDPOINT")
+	mflag.StringVar(&traceService, []string{"-trace_service_name"}, "docker-wicket", "service.name of spans")
+
+	mflag.DurationVar(&drainTimeout, []string{"-drain_timeout"}, 30*time.Second, "How long to wait for in-flight requests when shutting down")
+	mflag.DurationVar(&shutdownDelay, []string{"-shutdown_delay"}, 0, "How long to stay not-ready before draining, for load balancers to notice")
+
+	// https
+	mflag.StringVar(&tlsCertPath, []string{"-tls_cert"}, "", "Certificate file to serve https, empty to serve http")
+	mflag.StringVar(&tlsKeyPath, []string{"-tls_key"}, "", "Key file of --tls_cert")
+	mflag.StringVar(&tlsMinVersion, []string{"-tls_min_version"}, "1.2", "Minimum TLS version, 1.0, 1.1, 1.2 or 1.3")
+	mflag.StringVar(&tlsCiphers, []string{"-tls_ciphers"}, "", "Comma separated cipher suites for TLS 1.2 and below, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, empty for Go's default")
+	mflag.StringVar(&tlsClientCAPath, []string{"-tls_client_ca"}, "", "CA file to verify client certificates, which are then accepted as identity")
+	mflag.Var(&tlsClientUsernames, []string{"-tls_client_username"}, "Template mapping a client certificate to username, default {{.Subject.CommonName}}, can be repeated")
 
 	// acl
-	var aclDriverName string
-	mflag.StringVar(&aclDriverName, []string{"-acl_driver"}, "", "ACL Driver for Docker Wicket")
+	mflag.StringVar(&aclDriverName, []string{"-acl_driver"}, "", "ACL Driver for Docker Wicket, or comma separated drivers and instances of the config file, tried in order")
+
+	// audit
+	mflag.Var(&auditSinks, []string{"-audit_sink"}, "Where audit events go, file:///path?max_size_mb=100&max_backups=5, syslog:///, syslog://host:514 or http://collector/path, can be repeated")
+
+	// robots and management api
+	mflag.StringVar(&robotFile, []string{"-robot_file"}, "", "File to store robot accounts, empty to disable robots")
+	mflag.StringVar(&tokenFile, []string{"-token_file"}, "", "File to store personal access tokens, empty to disable tokens")
+	mflag.StringVar(&adminUsers, []string{"-admin_users"}, "", "Comma separated users who can manage robots and backup the index via /api")
 
 	// token for v1 and v2
-	mflag.StringVar(&tokenAuth.Issuer, []string{"-issuer"}, "docker-wicket", "Issuer of the token, MUST be same as what in registy2")
-	mflag.StringVar(&tokenAuth.Service, []string{"-service"}, "registry", "Service of the token")
-	mflag.Int64Var(&tokenAuth.Expiration, []string{"-expiration"}, 600, "how long the token can be treated as valid. (sec)")
+	mflag.StringVar(&tokenIssuer, []string{"-issuer"}, "docker-wicket", "Issuer of the token, MUST be same as what in registy2")
+	mflag.StringVar(&tokenService, []string{"-service"}, "registry", "Service of the token")
+	mflag.Int64Var(&tokenExpiration, []string{"-expiration"}, 600, "how long the token can be treated as valid. (sec)")
 
 	// cert and key for token
-	var certPath string
-	var certKeyPath string
 	mflag.StringVar(&certPath, []string{"-cert"}, "", "Token certificate file path, MUST be in the bundle of registy2")
 	mflag.StringVar(&certKeyPath, []string{"-key"}, "", "Key file path to token certificate")
+	mflag.DurationVar(&certExpiryWarning, []string{"-cert_expiry_warning"}, 7*24*time.Hour, "Token certificate expiring within is reported unhealthy")
 
 	// v1 only
-	var indexDriverName string
-	var v1Endpoint string
 	mflag.StringVar(&v1Endpoint, []string{"-v1_endpoint"}, "", "Endpoint of registry1")
 	mflag.StringVar(&indexDriverName, []string{"-v1_index_driver"}, "", "Index driver of registry1")
 
+	// options of drivers, e.g. --acl_htpasswd_file
+	registerDriverFlags()
+
 	parseConf()
 
-	err := tokenAuth.LoadCertAndKey(certPath, certKeyPath)
+	if err := setupLogging(); err != nil {
+		fatal("Cannot setup logging", "err", err)
+	}
+
+	args := mflag.Args()
+
+	if len(args) == 0 {
+		serve()
+		return
+	}
+
+	cmd, ok := commands[args[0]]
+
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %v\n", args[0])
+		mflag.Usage()
+		os.Exit(2)
+	}
+
+	if err := cmd(args[1:]); err != nil {
+		fatal("Command failed", "command", args[0], "err", err)
+	}
+}
+
+func serve() {
+
+	auditLogger, err := audit.Open(auditSinks)
+	if err != nil {
+		fatal("Cannot open audit sinks", "err", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(traceExporter, traceEndpoint, traceService)
 	if err != nil {
-		log.Fatalf("Cannot load cert: %v", err)
+		fatal("Cannot setup tracing", "err", err)
 	}
 
-	acldriver, err := acl.Load(aclDriverName)
+	i, err := build(auditLogger)
 	if err != nil {
-		log.Fatalf("Cannot load ACL Driver: %v", err)
+		fatal("Cannot start", "err", err)
 	}
 
-	indexdriver, err := index.Load(indexDriverName)
+	running.Store(i)
+
+	proxies, err := handler.ParseTrustedProxies(splitList(trustedProxies))
 	if err != nil {
-		log.Fatalf("Cannot load index Driver: %v", err)
+		fatal("Cannot parse trusted proxies", "err", err)
 	}
 
 	router := web.New(handler.ShareWebContext{}).
-		Middleware(web.LoggerMiddleware)
-
-	v1.InstallHandler(router, &v1.RunningContext{
-		RunningContext: handler.RunningContext{
-			TokenAuth: tokenAuth,
-			Acl:       acldriver,
-		},
-		// spec
-		Endpoints: v1Endpoint,
-		Index:     indexdriver,
-	})
+		Middleware(handler.RealIP(proxies)).
+		Middleware(tracing.Middleware).
+		Middleware((*handler.ShareWebContext).RequestLogger).
+		Middleware(metrics.Middleware)
 
-	v2.InstallHandler(router, &v2.RunningContext{
-		RunningContext: handler.RunningContext{
-			Acl:       acldriver,
-			TokenAuth: tokenAuth,
-		},
+	metrics.InstallHandler(router)
+	metrics.WatchCertExpiry(func() time.Time {
+		return running.Load().v2.TokenAuth.CertNotAfter()
 	})
 
-	log.Printf("Docker wicket @ %v:%v", ListenAddr, Port)
+	h := i.install(router)
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%v:%v", ListenAddr, Port),
+		Handler: router,
+	}
+
+	errc := make(chan error, 1)
+
+	servingTLS = currentTLSSettings()
+
+	if tlsCertPath == "" {
+		slog.Info("Docker wicket started", "addr", "http://"+server.Addr)
+
+		go func() { errc <- server.ListenAndServe() }()
+	} else {
+		server.TLSConfig, err = tlsConfig()
+		if err != nil {
+			fatal("Cannot load tls config", "err", err)
+		}
+
+		slog.Info("Docker wicket started", "addr", "https://"+server.Addr)
+
+		// cert and key are in TLSConfig.GetCertificate, reloaded on SIGHUP or change
+		go func() { errc <- server.ListenAndServeTLS("", "") }()
+	}
+
+	readiness.Set(true)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+wait:
+	for {
+		select {
+		case err := <-errc:
+			fatal("Cannot serve", "err", err)
+		case <-hup:
+			next, err := reload(h, running.Load(), auditLogger)
+
+			if err != nil {
+				slog.Error("Cannot reload, keep serving with old config", "err", err)
+				continue
+			}
+
+			running.Store(next)
+
+			slog.Info("Reloaded", "acl_driver", aclDriverName, "index_driver", indexDriverName)
+		case sig := <-stop:
+			slog.Info("Shutting down", "signal", sig.String())
+			break wait
+		}
+	}
+
+	readiness.Set(false)
+	time.Sleep(shutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	// in-flight token requests and index writes are finished here
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Cannot drain all connections", "err", err)
+	}
+
+	running.Load().close()
+
+	if err := auditLogger.Close(); err != nil {
+		slog.Warn("Cannot close audit sinks", "err", err)
+	}
+
+	// ctx may have been used up by draining
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("Cannot flush spans", "err", err)
+	}
+
+	slog.Info("Docker wicket stopped")
+}
+
+// repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Reset makes a list in config file one item per value, see config.Repeated
+func (l *stringList) Reset() {
+	*l = nil
+}
+
+func splitList(s string) []string {
+	l := make([]string, 0)
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf("%v:%v", ListenAddr, Port), router))
+	return l
 }
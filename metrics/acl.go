@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/tg123/docker-wicket/acl"
+)
+
+type aclDriver struct {
+	acl.Driver
+
+	name string
+}
+
+// ACL counts decisions of d, labelled with name
+// Authenticate, WithContext, Check and Close are passed to d
+func ACL(name string, d acl.Driver) acl.Driver {
+	return &aclDriver{d, name}
+}
+
+func (d *aclDriver) observeLogin(start time.Time, ok bool, err error) {
+	aclDuration.WithLabelValues(d.name, "login").Observe(since(start))
+	aclDecisions.WithLabelValues(d.name, "login", "", result(ok, err)).Inc()
+}
+
+func (d *aclDriver) observeAccess(start time.Time, perm acl.Permission, ok bool, err error) {
+	aclDuration.WithLabelValues(d.name, "access").Observe(since(start))
+	aclDecisions.WithLabelValues(d.name, "access", perm.String(), result(ok, err)).Inc()
+}
+
+func (d *aclDriver) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	start := time.Now()
+
+	ok, err := d.Driver.CanLogin(username, password)
+
+	d.observeLogin(start, ok, err)
+
+	return ok, err
+}
+
+func (d *aclDriver) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	start := time.Now()
+
+	ok, err := d.Driver.CanAccess(username, namespace, repo, perm)
+
+	d.observeAccess(start, perm, ok, err)
+
+	return ok, err
+}
+
+func (d *aclDriver) Authenticate(cred *acl.Credential) (acl.Session, error) {
+	start := time.Now()
+
+	// Login falls back to CanLogin, which is not observed again
+	s, err := acl.Login(d.Driver, cred)
+
+	d.observeLogin(start, s != nil, err)
+
+	if s == nil {
+		return nil, err
+	}
+
+	return &session{s, d}, nil
+}
+
+func (d *aclDriver) WithContext(ctx context.Context) acl.Driver {
+	return &aclDriver{acl.WithContext(d.Driver, ctx), d.name}
+}
+
+func (d *aclDriver) Check() error {
+	return acl.Check(d.Driver)
+}
+
+func (d *aclDriver) Close() error {
+	return acl.Close(d.Driver)
+}
+
+type session struct {
+	acl.Session
+
+	driver *aclDriver
+}
+
+func (s *session) ByUsername() bool {
+	return acl.ByUsername(s.Session)
+}
+
+func (s *session) CanAccess(namespace, repo string, perm acl.Permission) (bool, error) {
+	start := time.Now()
+
+	ok, err := s.Session.CanAccess(namespace, repo, perm)
+
+	s.driver.observeAccess(start, perm, ok, err)
+
+	return ok, err
+}
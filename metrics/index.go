@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/tg123/docker-wicket/index"
+)
+
+type indexDriver struct {
+	index.Driver
+}
+
+// Index counts operations of d
+// WithContext, Check, Backup and Close are passed to d
+func Index(d index.Driver) index.Driver {
+	return &indexDriver{d}
+}
+
+func observeIndex(op string, start time.Time, err error) {
+	indexDuration.WithLabelValues(op).Observe(since(start))
+
+	if err != nil {
+		indexOps.WithLabelValues(op, "error").Inc()
+	} else {
+		indexOps.WithLabelValues(op, "ok").Inc()
+	}
+}
+
+func (d *indexDriver) GetIndexImages(namespace, repo string) ([]index.Image, error) {
+	start := time.Now()
+
+	images, err := d.Driver.GetIndexImages(namespace, repo)
+
+	observeIndex("get_images", start, err)
+
+	return images, err
+}
+
+func (d *indexDriver) UpdateIndexImages(namespace, repo string, images []index.Image) error {
+	start := time.Now()
+
+	err := d.Driver.UpdateIndexImages(namespace, repo, images)
+
+	observeIndex("update_images", start, err)
+
+	return err
+}
+
+func (d *indexDriver) UpdateIndex(namespace, repo string, f index.UpdateFunc) error {
+	start := time.Now()
+
+	err := d.Driver.UpdateIndex(namespace, repo, f)
+
+	observeIndex("update_index", start, err)
+
+	return err
+}
+
+func (d *indexDriver) CreateRepo(namespace, repo string) error {
+	start := time.Now()
+
+	err := d.Driver.CreateRepo(namespace, repo)
+
+	observeIndex("create_repo", start, err)
+
+	return err
+}
+
+func (d *indexDriver) DeleteRepo(namespace, repo string) error {
+	start := time.Now()
+
+	err := d.Driver.DeleteRepo(namespace, repo)
+
+	observeIndex("delete_repo", start, err)
+
+	return err
+}
+
+func (d *indexDriver) Search(query string) ([]index.Repository, error) {
+	start := time.Now()
+
+	l, err := d.Driver.Search(query)
+
+	observeIndex("search", start, err)
+
+	return l, err
+}
+
+func (d *indexDriver) WithContext(ctx context.Context) index.Driver {
+	return &indexDriver{index.WithContext(d.Driver, ctx)}
+}
+
+func (d *indexDriver) Check() error {
+	return index.Check(d.Driver)
+}
+
+func (d *indexDriver) Backup(w io.Writer) error {
+	return index.Backup(d.Driver, w)
+}
+
+func (d *indexDriver) Close() error {
+	return index.Close(d.Driver)
+}
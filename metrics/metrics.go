@@ -0,0 +1,126 @@
+// Package metrics exports prometheus metrics of token issuance, acl decisions, index operations and requests
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gocraft/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "wicket"
+
+var (
+	aclDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "acl_decisions_total",
+		Help:      "ACL decisions, op is login or access, result is allow, deny or error",
+	}, []string{"driver", "op", "permission", "result"})
+
+	aclDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "acl_duration_seconds",
+		Help:      "Time spent in ACL driver",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"driver", "op"})
+
+	tokensIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_issued_total",
+		Help:      "Tokens issued, by granted action, none for tokens without access",
+	}, []string{"api", "action"})
+
+	indexOps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "index_operations_total",
+		Help:      "v1 index driver operations",
+	}, []string{"op", "result"})
+
+	indexDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "index_duration_seconds",
+		Help:      "Time spent in index driver",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(aclDecisions, aclDuration, tokensIssued, indexOps, indexDuration, requestDuration)
+}
+
+func result(ok bool, err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	if ok {
+		return "allow"
+	}
+
+	return "deny"
+}
+
+func since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// TokenIssued counts a token issued by api (v1 or v2) with actions granted
+func TokenIssued(api string, actions []string) {
+
+	if len(actions) == 0 {
+		tokensIssued.WithLabelValues(api, "none").Inc()
+		return
+	}
+
+	for _, a := range actions {
+		tokensIssued.WithLabelValues(api, a).Inc()
+	}
+}
+
+// WatchCertExpiry exports notAfter of the token signing cert as a gauge
+func WatchCertExpiry(notAfter func() time.Time) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "token_cert_expiry_timestamp_seconds",
+		Help:      "When the token signing cert expires, unix time",
+	}, func() float64 {
+		return float64(notAfter().Unix())
+	}))
+}
+
+// Middleware observes request latency by route pattern, not by path, to keep labels bounded
+func Middleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	start := time.Now()
+
+	next(rw, req)
+
+	route := req.RoutePath()
+
+	if route == "" {
+		route = "unmatched"
+	}
+
+	requestDuration.WithLabelValues(route, req.Method, strconv.Itoa(rw.StatusCode())).Observe(since(start))
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func InstallHandler(rootRouter *web.Router) {
+	h := Handler()
+
+	rootRouter.Get("/metrics", func(rw web.ResponseWriter, req *web.Request) {
+		h.ServeHTTP(rw, req.Request)
+	})
+}
@@ -0,0 +1,117 @@
+package pat
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/tg123/docker-wicket/acl"
+)
+
+// Driver accepts tokens as password in front of any acl.Driver
+// other passwords go to the wrapped driver
+type Driver struct {
+	acl.Driver
+
+	Store Store
+
+	// of the request served, nil if none
+	ctx context.Context
+}
+
+func Wrap(store Store, driver acl.Driver) *Driver {
+	return &Driver{
+		Driver: driver,
+		Store:  store,
+	}
+}
+
+func (d *Driver) Authenticate(cred *acl.Credential) (acl.Session, error) {
+
+	id, secret, ok := Parse(cred.Password)
+
+	if !ok {
+		return acl.Login(d.Driver, cred)
+	}
+
+	t, err := d.Store.Get(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// no such token, the password may just look like one
+	if t == nil {
+		return acl.Login(d.Driver, cred)
+	}
+
+	if t.Owner != cred.Username || t.Expired() || !t.Match(secret) {
+		return nil, nil
+	}
+
+	// login should not fail because of bookkeeping
+	if err := d.Store.Touch(t.ID, time.Now().Unix(), cred.RemoteAddr); err != nil {
+		slog.WarnContext(d.context(), "Cannot record usage of token", "token", t.ID, "err", err)
+	}
+
+	return &session{
+		owner:  acl.NewSession(d.Driver, t.Owner),
+		grants: t.Grants,
+	}, nil
+}
+
+func (d *Driver) WithContext(ctx context.Context) acl.Driver {
+	return &Driver{
+		Driver: acl.WithContext(d.Driver, ctx),
+		Store:  d.Store,
+		ctx:    ctx,
+	}
+}
+
+func (d *Driver) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+
+	return d.ctx
+}
+
+func (d *Driver) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	s, err := d.Authenticate(&acl.Credential{
+		Username: username,
+		Password: password,
+	})
+
+	return s != nil, err
+}
+
+// a token can do what both its grants and its owner allow
+type session struct {
+	owner  acl.Session
+	grants acl.Grants
+}
+
+func (s *session) Username() acl.Username {
+	return s.owner.Username()
+}
+
+func (s *session) CanAccess(namespace, repo string, perm acl.Permission) (bool, error) {
+
+	if !s.grants.Allows(namespace, repo, perm) {
+		return false, nil
+	}
+
+	return s.owner.CanAccess(namespace, repo, perm)
+}
+
+func (d *Driver) Close() error {
+	return acl.Close(d.Driver)
+}
+
+func (d *Driver) Check() error {
+	if _, err := d.Store.List(acl.Anonymous); err != nil {
+		return err
+	}
+
+	return acl.Check(d.Driver)
+}
@@ -0,0 +1,177 @@
+package pat
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tg123/docker-wicket/acl"
+)
+
+// users log in with their password and own the namespace of their name
+type users map[acl.Username]acl.Password
+
+func (u users) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	p, ok := u[username]
+	return ok && p == password, nil
+}
+
+func (u users) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	return username != acl.Anonymous && string(username) == namespace, nil
+}
+
+func newDriver(t *testing.T) *Driver {
+	return Wrap(NewFileStore(filepath.Join(t.TempDir(), "tokens.json")), users{
+		"user1": "pass1",
+
+		// looks like a token, but is a password
+		"user2": "wkt_0000_pass2",
+	})
+}
+
+// create creates a token of owner with grants, returns its password
+func create(t *testing.T, d *Driver, owner acl.Username, ttl time.Duration, grants ...string) (*Token, string) {
+
+	var gs acl.Grants
+
+	for _, g := range grants {
+		if err := gs.Set(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tk, password, err := New(owner, gs, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Store.Create(tk); err != nil {
+		t.Fatal(err)
+	}
+
+	return tk, password
+}
+
+func login(t *testing.T, d *Driver, username acl.Username, password string) acl.Session {
+
+	s, err := d.Authenticate(&acl.Credential{Username: username, Password: acl.Password(password)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestScope(t *testing.T) {
+
+	d := newDriver(t)
+
+	_, password := create(t, d, "user1", 0, "user1/app:pull", "user2/*:pull,push")
+
+	s := login(t, d, "user1", password)
+
+	if s == nil {
+		t.Fatal("token rejected")
+	}
+
+	if s.Username() != "user1" || acl.ByUsername(s) {
+		t.Fatalf("bad session of %v", s.Username())
+	}
+
+	for _, c := range []struct {
+		namespace string
+		repo      string
+		perm      acl.Permission
+		want      bool
+	}{
+		{"user1", "app", acl.READ, true},
+
+		// the owner can, the token can not
+		{"user1", "app", acl.WRITE, false},
+		{"user1", "other", acl.READ, false},
+
+		// the token can, the owner can not
+		{"user2", "app", acl.READ, false},
+	} {
+		got, err := s.CanAccess(c.namespace, c.repo, c.perm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != c.want {
+			t.Errorf("%v/%v %v: got %v, want %v", c.namespace, c.repo, c.perm, got, c.want)
+		}
+	}
+
+	tk, _, _ := Parse(acl.Password(password))
+
+	if used, err := d.Store.Get(tk); err != nil || used.LastUsed == 0 {
+		t.Fatalf("use not recorded: %v", err)
+	}
+}
+
+func TestReject(t *testing.T) {
+
+	d := newDriver(t)
+
+	_, password := create(t, d, "user1", 0, "user1/*:pull")
+
+	if login(t, d, "user2", password) != nil {
+		t.Fatal("token of user1 accepted for user2")
+	}
+
+	if login(t, d, "user1", password+"x") != nil {
+		t.Fatal("wrong secret accepted")
+	}
+
+	tk, expiring := create(t, d, "user1", time.Hour, "user1/*:pull")
+
+	if login(t, d, "user1", expiring) == nil {
+		t.Fatal("token rejected before it expires")
+	}
+
+	// expire it
+	if err := d.Store.Delete(tk.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tk.Expires = time.Now().Add(-time.Second).Unix()
+
+	if err := d.Store.Create(tk); err != nil {
+		t.Fatal(err)
+	}
+
+	if login(t, d, "user1", expiring) != nil {
+		t.Fatal("expired token accepted")
+	}
+
+	if err := d.Store.Delete(tk.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if login(t, d, "user1", password) == nil {
+		t.Fatal("other token rejected")
+	}
+
+	if login(t, d, "user1", expiring) != nil {
+		t.Fatal("deleted token accepted")
+	}
+}
+
+func TestPassword(t *testing.T) {
+
+	d := newDriver(t)
+
+	if s := login(t, d, "user1", "pass1"); s == nil || !acl.ByUsername(s) {
+		t.Fatal("password rejected")
+	}
+
+	// no token of that id, tried as password
+	if login(t, d, "user2", "wkt_0000_pass2") == nil {
+		t.Fatal("password looking like a token rejected")
+	}
+
+	if login(t, d, "user1", "wkt_0000_pass2") != nil {
+		t.Fatal("password of user2 accepted for user1")
+	}
+}
@@ -0,0 +1,72 @@
+package pat
+
+import (
+	"sort"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/credential"
+)
+
+// no need to rewrite the file on every login
+const touchInterval = 60 // sec
+
+// FileStore keeps all tokens in one json file
+type FileStore struct {
+	file *credential.File[Token]
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{credential.NewFile[Token](path, "token")}
+}
+
+func (s *FileStore) List(owner acl.Username) ([]*Token, error) {
+
+	m, err := s.file.All()
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := make([]*Token, 0)
+
+	for _, t := range m {
+		if t.Owner == owner {
+			l = append(l, t)
+		}
+	}
+
+	sort.Sort(byCreated(l))
+
+	return l, nil
+}
+
+func (s *FileStore) Get(id string) (*Token, error) {
+	return s.file.Get(id)
+}
+
+func (s *FileStore) Create(t *Token) error {
+	return s.file.Create(t.ID, t)
+}
+
+func (s *FileStore) Delete(id string) error {
+	return s.file.Delete(id)
+}
+
+func (s *FileStore) Touch(id string, at int64, ip string) error {
+	return s.file.Update(id, func(t *Token) bool {
+		if t.LastUsedIP == ip && at-t.LastUsed < touchInterval {
+			return false
+		}
+
+		t.LastUsed = at
+		t.LastUsedIP = ip
+
+		return true
+	})
+}
+
+type byCreated []*Token
+
+func (l byCreated) Len() int           { return len(l) }
+func (l byCreated) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l byCreated) Less(i, j int) bool { return l[i].Created < l[j].Created }
@@ -0,0 +1,93 @@
+package pat
+
+// personal access tokens, used as password in `docker login`,
+// each token can only do a subset of what its owner can do
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/credential"
+)
+
+// tokens look like wkt_<id>_<secret>
+const Prefix = "wkt_"
+
+type Token struct {
+	ID          string       `json:"id"`
+	Owner       acl.Username `json:"owner"`
+	Description string       `json:"description,omitempty"`
+	Grants      acl.Grants   `json:"grants"`
+
+	credential.Secret
+
+	LastUsed   int64  `json:"last_used,omitempty"`
+	LastUsedIP string `json:"last_used_ip,omitempty"`
+}
+
+type Store interface {
+	// List returns tokens of owner
+	List(owner acl.Username) ([]*Token, error)
+
+	// Get returns nil if no such token
+	Get(id string) (*Token, error)
+
+	Create(t *Token) error
+
+	Delete(id string) error
+
+	// Touch records the token is used at time from ip
+	Touch(id string, at int64, ip string) error
+}
+
+// New creates a token for owner, returns the token and the string used as password
+// only the hash of the secret is kept in Token
+func New(owner acl.Username, grants acl.Grants, ttl time.Duration) (*Token, string, error) {
+
+	if len(grants) == 0 {
+		return nil, "", fmt.Errorf("token needs at least one grant")
+	}
+
+	b := make([]byte, 8)
+
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+
+	id := hex.EncodeToString(b)
+
+	secret, password, err := credential.NewSecret(ttl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	t := &Token{
+		ID:     id,
+		Owner:  owner,
+		Grants: grants,
+		Secret: secret,
+	}
+
+	return t, Prefix + id + "_" + password, nil
+}
+
+// Parse splits a password into token id and secret, false if it is not a token
+func Parse(password acl.Password) (id, secret string, ok bool) {
+	s := string(password)
+
+	if !strings.HasPrefix(s, Prefix) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(s, Prefix), "_", 2)
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,282 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client calls a plugin, which is started or dialed on first call and again after it breaks
+type Client struct {
+	command []string
+	address string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	rpc    *rpc.Client
+	cmd    *exec.Cmd
+	closed bool
+
+	stop chan struct{}
+}
+
+// NewClient starts command, or dials address if command is empty, and pings it every healthInterval, 0 not to
+// calls not done in timeout are failed, and the plugin is restarted
+func NewClient(command []string, address string, timeout, healthInterval time.Duration) (*Client, error) {
+
+	if len(command) == 0 && address == "" {
+		return nil, fmt.Errorf("neither plugin command nor address set")
+	}
+
+	if timeout <= 0 {
+		return nil, fmt.Errorf("bad plugin timeout %v", timeout)
+	}
+
+	c := &Client{
+		command: command,
+		address: address,
+		timeout: timeout,
+		stop:    make(chan struct{}),
+	}
+
+	// fail fast on a plugin which does not even start
+	if err := c.Ping(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	if healthInterval > 0 {
+		go c.watch(healthInterval)
+	}
+
+	return c, nil
+}
+
+func (c *Client) String() string {
+	if len(c.command) > 0 {
+		return c.command[0]
+	}
+
+	return c.address
+}
+
+// id tells plugins apart, the same command line or address is the same plugin
+func (c *Client) id() string {
+	if len(c.command) > 0 {
+		return strings.Join(c.command, " ")
+	}
+
+	return c.address
+}
+
+// Ping runs the plugin's own check
+func (c *Client) Ping() error {
+	var ok bool
+	return c.Call("Plugin.Ping", struct{}{}, &ok)
+}
+
+func (c *Client) watch(interval time.Duration) {
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+			// a broken plugin is restarted by the call
+			if err := c.Ping(); err != nil {
+				slog.Warn("Plugin health check failed", "plugin", c.String(), "err", err)
+			}
+		}
+	}
+}
+
+// Call calls method of the plugin, errors returned by the plugin itself leave it running
+func (c *Client) Call(method string, args, reply any) error {
+
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+
+	t := time.NewTimer(c.timeout)
+	defer t.Stop()
+
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-t.C:
+		err = fmt.Errorf("plugin %v timed out after %v", method, c.timeout)
+	}
+
+	var serverErr rpc.ServerError
+
+	if err != nil && !errors.As(err, &serverErr) {
+		c.drop(client, err)
+	}
+
+	return err
+}
+
+func (c *Client) client() (*rpc.Client, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, fmt.Errorf("plugin %v closed", c)
+	}
+
+	if c.rpc != nil {
+		return c.rpc, nil
+	}
+
+	conn, cmd, err := c.connect()
+	if err != nil {
+		return nil, fmt.Errorf("cannot start plugin %v: %v", c, err)
+	}
+
+	slog.Info("Plugin started", "plugin", c.String())
+
+	c.rpc = jsonrpc.NewClient(conn)
+	c.cmd = cmd
+
+	return c.rpc, nil
+}
+
+func (c *Client) connect() (io.ReadWriteCloser, *exec.Cmd, error) {
+
+	if len(c.command) == 0 {
+		u, err := url.Parse(c.address)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch u.Scheme {
+		case "unix":
+			conn, err := net.DialTimeout("unix", u.Path, c.timeout)
+			return conn, nil, err
+		case "tcp":
+			conn, err := net.DialTimeout("tcp", u.Host, c.timeout)
+			return conn, nil, err
+		}
+
+		return nil, nil, fmt.Errorf("unknown address scheme %q, unix or tcp", u.Scheme)
+	}
+
+	cmd := exec.Command(c.command[0], c.command[1:]...)
+	// plugins log to stderr, along with ours
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	return stdio{stdout, stdin}, cmd, nil
+}
+
+// drop throws client away, if it is still the one in use, so that the next call restarts the plugin
+func (c *Client) drop(client *rpc.Client, err error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rpc != client {
+		return
+	}
+
+	slog.Warn("Plugin broken, restarting", "plugin", c.String(), "err", err)
+
+	c.kill()
+}
+
+// kill closes the connection and stops the process, with c.mu held
+func (c *Client) kill() {
+
+	if c.rpc != nil {
+		// the plugin sees EOF on stdin
+		c.rpc.Close()
+		c.rpc = nil
+	}
+
+	if cmd := c.cmd; cmd != nil {
+		c.cmd = nil
+
+		exited := make(chan struct{})
+
+		go func() {
+			cmd.Wait()
+			close(exited)
+		}()
+
+		go func() {
+			select {
+			case <-exited:
+			case <-time.After(c.timeout):
+				cmd.Process.Kill()
+			}
+		}()
+	}
+}
+
+func (c *Client) Close() error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	close(c.stop)
+	c.kill()
+
+	return nil
+}
+
+// stdio of a plugin process as a connection
+type stdio struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (s stdio) Close() error {
+	werr := s.WriteCloser.Close()
+	rerr := s.ReadCloser.Close()
+
+	if werr != nil {
+		return werr
+	}
+
+	return rerr
+}
+
+// splitCommand splits a command line by spaces, no quoting
+func splitCommand(s string) []string {
+	return strings.Fields(s)
+}
@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/driver"
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/requestid"
+)
+
+var options = []driver.Option{
+	{Name: "command", Usage: "Command line of the plugin, split by spaces, started by wicket and restarted when broken"},
+	{Name: "address", Usage: "unix:///path or tcp://host:port of a running plugin, instead of command"},
+	{Name: "timeout", Usage: "How long a call can take before the plugin is treated as broken", Default: "5s"},
+	{Name: "health_interval", Usage: "How often the plugin is pinged, 0 not to", Default: "10s"},
+}
+
+func init() {
+	acl.Register("plugin", options, func(c driver.Config) (acl.Driver, error) {
+		client, err := open(c)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ACL{Client: client}, nil
+	})
+
+	index.Register("plugin", options, func(c driver.Config) (index.Driver, error) {
+		client, err := open(c)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Index{Client: client}, nil
+	})
+}
+
+func open(c driver.Config) (*Client, error) {
+
+	timeout, err := c.Duration("timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	interval, err := c.Duration("health_interval")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(splitCommand(c.String("command")), c.String("address"), timeout, interval)
+}
+
+// ACL is an acl.Driver served by a plugin
+type ACL struct {
+	*Client
+
+	requestID string
+}
+
+func (d *ACL) WithContext(ctx context.Context) acl.Driver {
+	return &ACL{Client: d.Client, requestID: requestid.From(ctx)}
+}
+
+func (d *ACL) CanLogin(username acl.Username, password acl.Password) (ok bool, err error) {
+	err = d.Call("ACL.CanLogin", &LoginArgs{string(username), string(password), d.requestID}, &ok)
+	return
+}
+
+func (d *ACL) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (ok bool, err error) {
+	err = d.Call("ACL.CanAccess", &AccessArgs{string(username), namespace, repo, perm.String(), d.requestID}, &ok)
+	return
+}
+
+func (d *ACL) Check() error {
+	return d.Ping()
+}
+
+// Index is an index.Driver served by a plugin
+type Index struct {
+	*Client
+
+	requestID string
+}
+
+// by plugin and repo, shared by drivers of the same plugin, say, the old and new one during reload
+var locks index.RepoLocks
+
+func (d *Index) WithContext(ctx context.Context) index.Driver {
+	return &Index{Client: d.Client, requestID: requestid.From(ctx)}
+}
+
+func (d *Index) GetIndexImages(namespace, repo string) (images []index.Image, err error) {
+	err = d.Call("Index.GetIndexImages", &RepoArgs{namespace, repo, d.requestID}, &images)
+	return
+}
+
+func (d *Index) UpdateIndexImages(namespace, repo string, images []index.Image) error {
+	var ok bool
+	return d.Call("Index.UpdateIndexImages", &ImagesArgs{namespace, repo, images, d.requestID}, &ok)
+}
+
+// UpdateIndex is get and update under a lock in wicket, the plugin sees no difference from UpdateIndexImages
+func (d *Index) UpdateIndex(namespace, repo string, f index.UpdateFunc) error {
+	return locks.Update(d, d.id()+"\x00"+namespace+"/"+repo, namespace, repo, f)
+}
+
+func (d *Index) CreateRepo(namespace, repo string) error {
+	var ok bool
+	return d.Call("Index.CreateRepo", &RepoArgs{namespace, repo, d.requestID}, &ok)
+}
+
+func (d *Index) DeleteRepo(namespace, repo string) error {
+	var ok bool
+
+	err := d.Call("Index.DeleteRepo", &RepoArgs{namespace, repo, d.requestID}, &ok)
+
+	// errors cross as strings
+	if err != nil && err.Error() == index.ErrRepoNotFound.Error() {
+		return index.ErrRepoNotFound
+	}
+
+	return err
+}
+
+func (d *Index) Check() error {
+	return d.Ping()
+}
+
+func (d *Index) Search(query string) (repos []index.Repository, err error) {
+	err = d.Call("Index.Search", &SearchArgs{query, d.requestID}, &repos)
+	return
+}
@@ -0,0 +1,83 @@
+// Package plugin runs acl and index drivers out of process, so that a driver can be shipped without rebuilding wicket
+//
+// the protocol is JSON-RPC 1.0, as net/rpc/jsonrpc speaks, over stdin/stdout of a process wicket starts,
+// or over a connection to unix:///path or tcp://host:port of one already running
+//
+//	--> {"id": 1, "method": "ACL.CanLogin", "params": [{"username": "user1", "password": "secret"}]}
+//	<-- {"id": 1, "result": true, "error": null}
+//
+// methods are
+//
+//	Plugin.Ping      {}                                                   -> true, an error if the plugin's own check fails
+//	ACL.CanLogin     {"username", "password"}                             -> bool
+//	ACL.CanAccess    {"username", "namespace", "repo", "permission"}      -> bool, permission is read, write or delete
+//	Index.GetIndexImages    {"namespace", "repo"}                         -> [{"id", "checksum", "pushed_at", "pusher", "tags"}]
+//	Index.UpdateIndexImages {"namespace", "repo", "images"}               -> true
+//	Index.CreateRepo        {"namespace", "repo"}                         -> true
+//	Index.DeleteRepo        {"namespace", "repo"}                         -> true, error "repo not found" if not there
+//	Index.Search            {"query"}                                     -> [{"namespace", "repo"}]
+//
+// params also have "request_id" of the request wicket serves, if any, a plugin in Go gets it by requestid.From
+// of the context its drivers are bound to, see acl.ContextBinder
+//
+// a plugin in Go only needs ServeStdio, see example/plugin
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/index"
+)
+
+type LoginArgs struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// of the request wicket serves, for logs of the plugin, empty if none
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type AccessArgs struct {
+	Username   string `json:"username"`
+	Namespace  string `json:"namespace"`
+	Repo       string `json:"repo"`
+	Permission string `json:"permission"`
+
+	// of the request wicket serves, for logs of the plugin, empty if none
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type RepoArgs struct {
+	Namespace string `json:"namespace"`
+	Repo      string `json:"repo"`
+
+	// of the request wicket serves, for logs of the plugin, empty if none
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type SearchArgs struct {
+	Query string `json:"query"`
+
+	// of the request wicket serves, for logs of the plugin, empty if none
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type ImagesArgs struct {
+	Namespace string        `json:"namespace"`
+	Repo      string        `json:"repo"`
+	Images    []index.Image `json:"images"`
+
+	// of the request wicket serves, for logs of the plugin, empty if none
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func parsePermission(s string) (acl.Permission, error) {
+	for _, p := range []acl.Permission{acl.READ, acl.WRITE, acl.DELETE} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown permission %q", s)
+}
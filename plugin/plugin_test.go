@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/index/indextest"
+	"github.com/tg123/docker-wicket/index/mem"
+	"github.com/tg123/docker-wicket/requestid"
+)
+
+// binding lets username log in with the request id it is bound to as password
+type binding struct {
+	id string
+}
+
+func (b *binding) WithContext(ctx context.Context) acl.Driver {
+	return &binding{requestid.From(ctx)}
+}
+
+func (b *binding) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	return string(password) == b.id, nil
+}
+
+func (b *binding) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	return false, nil
+}
+
+// listen serves a and i to plugin clients on a unix socket
+func listen(t *testing.T, a acl.Driver, i index.Driver) string {
+
+	path := filepath.Join(t.TempDir(), "plugin.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go Serve(conn, a, i)
+		}
+	}()
+
+	return "unix://" + path
+}
+
+func TestRequestID(t *testing.T) {
+
+	client, err := NewClient(nil, listen(t, &binding{}, nil), time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	d := acl.WithContext(&ACL{Client: client}, requestid.With(context.Background(), "abc"))
+
+	if ok, err := d.CanLogin("user1", "abc"); !ok || err != nil {
+		t.Fatalf("plugin did not get the request id: %v", err)
+	}
+
+	// not bound, no request id
+	if ok, err := (&ACL{Client: client}).CanLogin("user1", "abc"); ok || err != nil {
+		t.Fatalf("plugin got a request id: %v", err)
+	}
+}
+
+// alternating updates with the drivers in turn
+type alternating struct {
+	index.Driver
+
+	others []index.Driver
+	n      atomic.Int64
+}
+
+func (a *alternating) UpdateIndex(namespace, repo string, f index.UpdateFunc) error {
+	return a.others[a.n.Add(1)%int64(len(a.others))].UpdateIndex(namespace, repo, f)
+}
+
+// drivers of one plugin, say, the old and new one during reload, do not lose each other's pushes
+func TestConcurrentPushes(t *testing.T) {
+
+	address := listen(t, nil, mem.New())
+
+	var drivers []index.Driver
+
+	for range 2 {
+		client, err := NewClient(nil, address, time.Second, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer client.Close()
+
+		drivers = append(drivers, &Index{Client: client})
+	}
+
+	indextest.ConcurrentPushes(t, &alternating{Driver: drivers[0], others: drivers}, 20, 5)
+}
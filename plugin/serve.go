@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/requestid"
+)
+
+// ServeStdio serves a and i, either can be nil, to wicket on stdin/stdout until wicket closes stdin
+func ServeStdio(a acl.Driver, i index.Driver) error {
+	return Serve(stdio{os.Stdin, os.Stdout}, a, i)
+}
+
+// Serve serves a and i, either can be nil, on conn until it is closed, say, one accepted from a listener
+func Serve(conn io.ReadWriteCloser, a acl.Driver, i index.Driver) error {
+
+	server := rpc.NewServer()
+
+	if err := server.RegisterName("Plugin", &pluginService{a, i}); err != nil {
+		return err
+	}
+
+	if a != nil {
+		if err := server.RegisterName("ACL", &aclService{a}); err != nil {
+			return err
+		}
+	}
+
+	if i != nil {
+		if err := server.RegisterName("Index", &indexService{i}); err != nil {
+			return err
+		}
+	}
+
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+
+	return nil
+}
+
+type pluginService struct {
+	acl   acl.Driver
+	index index.Driver
+}
+
+func (s *pluginService) Ping(args *struct{}, reply *bool) error {
+
+	if s.acl != nil {
+		if err := acl.Check(s.acl); err != nil {
+			return err
+		}
+	}
+
+	if s.index != nil {
+		if err := index.Check(s.index); err != nil {
+			return err
+		}
+	}
+
+	*reply = true
+
+	return nil
+}
+
+type aclService struct {
+	d acl.Driver
+}
+
+// bound returns the driver serving the request of id
+func (s *aclService) bound(id string) acl.Driver {
+	return acl.WithContext(s.d, requestid.With(context.Background(), id))
+}
+
+func (s *aclService) CanLogin(args *LoginArgs, reply *bool) (err error) {
+	*reply, err = s.bound(args.RequestID).CanLogin(acl.Username(args.Username), acl.Password(args.Password))
+	return
+}
+
+func (s *aclService) CanAccess(args *AccessArgs, reply *bool) error {
+
+	perm, err := parsePermission(args.Permission)
+	if err != nil {
+		return err
+	}
+
+	*reply, err = s.bound(args.RequestID).CanAccess(acl.Username(args.Username), args.Namespace, args.Repo, perm)
+
+	return err
+}
+
+type indexService struct {
+	d index.Driver
+}
+
+// bound returns the driver serving the request of id
+func (s *indexService) bound(id string) index.Driver {
+	return index.WithContext(s.d, requestid.With(context.Background(), id))
+}
+
+func (s *indexService) GetIndexImages(args *RepoArgs, reply *[]index.Image) (err error) {
+	*reply, err = s.bound(args.RequestID).GetIndexImages(args.Namespace, args.Repo)
+	return
+}
+
+func (s *indexService) UpdateIndexImages(args *ImagesArgs, reply *bool) error {
+	*reply = true
+	return s.bound(args.RequestID).UpdateIndexImages(args.Namespace, args.Repo, args.Images)
+}
+
+func (s *indexService) CreateRepo(args *RepoArgs, reply *bool) error {
+	*reply = true
+	return s.bound(args.RequestID).CreateRepo(args.Namespace, args.Repo)
+}
+
+func (s *indexService) DeleteRepo(args *RepoArgs, reply *bool) error {
+	*reply = true
+	return s.bound(args.RequestID).DeleteRepo(args.Namespace, args.Repo)
+}
+
+func (s *indexService) Search(args *SearchArgs, reply *[]index.Repository) (err error) {
+	*reply, err = s.bound(args.RequestID).Search(args.Query)
+	return
+}
@@ -0,0 +1,254 @@
+package main
+
+// everything built from flags which can change without a restart, rebuilt on SIGHUP
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/audit"
+	"github.com/tg123/docker-wicket/config"
+	"github.com/tg123/docker-wicket/handler"
+	"github.com/tg123/docker-wicket/handler/api"
+	"github.com/tg123/docker-wicket/handler/health"
+	"github.com/tg123/docker-wicket/handler/v1"
+	"github.com/tg123/docker-wicket/handler/v2"
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/metrics"
+	"github.com/tg123/docker-wicket/pat"
+	"github.com/tg123/docker-wicket/robot"
+)
+
+type instance struct {
+	acl   acl.Driver
+	index index.Driver
+
+	v1     *v1.RunningContext
+	v2     *v2.RunningContext
+	api    *api.RunningContext
+	health *health.RunningContext
+}
+
+// the instance serving new requests
+var running atomic.Pointer[instance]
+
+// token flags are kept apart from TokenAuth, which is rebuilt on reload
+func newTokenAuth() *handler.TokenAuth {
+	return &handler.TokenAuth{
+		Issuer:     tokenIssuer,
+		Service:    tokenService,
+		Expiration: tokenExpiration,
+	}
+}
+
+func build(auditLogger *audit.Logger) (*instance, error) {
+
+	tokenAuth := newTokenAuth()
+
+	if err := tokenAuth.LoadCertAndKey(certPath, certKeyPath); err != nil {
+		return nil, fmt.Errorf("cannot load cert: %v", err)
+	}
+
+	acldriver, err := loadACL()
+	if err != nil {
+		return nil, fmt.Errorf("cannot load ACL driver %v: %v", aclDriverName, err)
+	}
+
+	var robots robot.Store
+
+	if robotFile != "" {
+		robots = robot.NewFileStore(robotFile)
+		acldriver = robot.Wrap(robots, acldriver)
+	}
+
+	var tokens pat.Store
+
+	if tokenFile != "" {
+		tokens = pat.NewFileStore(tokenFile)
+		acldriver = pat.Wrap(tokens, acldriver)
+	}
+
+	acldriver = metrics.ACL(aclDriverName, acldriver)
+
+	indexdriver, err := loadIndex()
+	if err != nil {
+		acl.Close(acldriver)
+		return nil, fmt.Errorf("cannot load index driver %v: %v", indexDriverName, err)
+	}
+
+	indexdriver = metrics.Index(indexdriver)
+
+	i := &instance{
+		acl:   acldriver,
+		index: indexdriver,
+	}
+
+	rc := handler.RunningContext{
+		TokenAuth: tokenAuth,
+		Acl:       acldriver,
+		Audit:     auditLogger,
+	}
+
+	if tlsClientCAPath != "" {
+		usernames := tlsClientUsernames
+
+		if len(usernames) == 0 {
+			usernames = stringList{"{{.Subject.CommonName}}"}
+		}
+
+		rc.ClientCerts, err = handler.NewCertIdentity(usernames)
+		if err != nil {
+			i.close()
+			return nil, fmt.Errorf("bad client username template: %v", err)
+		}
+	}
+
+	i.v1 = &v1.RunningContext{
+		RunningContext: rc,
+		// spec
+		Endpoints: v1Endpoint,
+		Index:     indexdriver,
+	}
+
+	i.v2 = &v2.RunningContext{
+		RunningContext: rc,
+	}
+
+	i.api = &api.RunningContext{
+		RunningContext: rc,
+		Admins:         splitList(adminUsers),
+		Robots:         robots,
+		Tokens:         tokens,
+		Index:          indexdriver,
+	}
+
+	i.health = &health.RunningContext{
+		RunningContext:    rc,
+		Index:             indexdriver,
+		Readiness:         readiness,
+		CertExpiryWarning: certExpiryWarning,
+	}
+
+	return i, nil
+}
+
+// handlers are installed once, and updated with instances built on reload
+type handlers struct {
+	v1     *v1.Handler
+	v2     *v2.Handler
+	api    *api.Handler
+	health *health.Handler
+}
+
+func (i *instance) install(router *web.Router) *handlers {
+	return &handlers{
+		v1:     v1.InstallHandler(router, i.v1),
+		v2:     v2.InstallHandler(router, i.v2),
+		api:    api.InstallHandler(router, i.api),
+		health: health.InstallHandler(router, i.health),
+	}
+}
+
+// update swaps i in for new requests
+func (h *handlers) update(i *instance) {
+	h.v1.Update(i.v1)
+	h.v2.Update(i.v2)
+	h.api.Update(i.api)
+	h.health.Update(i.health)
+}
+
+// check runs self checks of drivers, say, db reachable
+func (i *instance) check() error {
+	if err := acl.Check(i.acl); err != nil {
+		return fmt.Errorf("ACL driver %v: %v", aclDriverName, err)
+	}
+
+	if err := index.Check(i.index); err != nil {
+		return fmt.Errorf("index driver %v: %v", indexDriverName, err)
+	}
+
+	return nil
+}
+
+func (i *instance) close() {
+	if err := acl.Close(i.acl); err != nil {
+		slog.Warn("Cannot close ACL Driver", "err", err)
+	}
+
+	if err := index.Close(i.index); err != nil {
+		slog.Warn("Cannot close index Driver", "err", err)
+	}
+}
+
+// reload applies the config file again and builds a new instance from it
+// if anything fails, flags are rolled back and old keeps serving
+func reload(h *handlers, old *instance, auditLogger *audit.Logger) (*instance, error) {
+
+	prev := loadedConfig
+
+	if configFile != "" {
+		f, err := config.Read(configFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Reset(setElsewhere)
+
+		if err := f.Apply(setElsewhere); err != nil {
+			rollback(prev)
+			return nil, err
+		}
+
+		loadedConfig = f
+	}
+
+	// only files of https are reloaded, by certReloader
+	if currentTLSSettings() != servingTLS {
+		rollback(prev)
+		return nil, fmt.Errorf("tls settings changed, they need a restart")
+	}
+
+	if err := setupLogging(); err != nil {
+		rollback(prev)
+		return nil, err
+	}
+
+	i, err := build(auditLogger)
+	if err != nil {
+		rollback(prev)
+		return nil, err
+	}
+
+	if err := i.check(); err != nil {
+		i.close()
+		rollback(prev)
+		return nil, err
+	}
+
+	h.update(i)
+
+	// requests in flight may still be using old drivers
+	time.AfterFunc(drainTimeout, old.close)
+
+	return i, nil
+}
+
+// rollback sets flags back to what f, the config file applied last time, gave
+func rollback(f *config.File) {
+
+	loadedConfig = f
+
+	if f != nil {
+		config.Reset(setElsewhere)
+
+		// applied fine last time
+		f.Apply(setElsewhere)
+	}
+
+	setupLogging()
+}
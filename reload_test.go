@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/tg123/docker-wicket/handler"
+	"github.com/tg123/docker-wicket/index"
+)
+
+func TestReloadKeepsMemIndex(t *testing.T) {
+
+	certPath, certKeyPath = writeCert(t, t.TempDir(), "token")
+	aclDriverName = "derelict"
+	indexDriverName = "mem"
+	logLevel = "info"
+	logFormat = "text"
+
+	// old is closed below, as if drained
+	drainTimeout = time.Hour
+
+	old, err := build(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := old.install(web.New(handler.ShareWebContext{}))
+
+	if err := old.index.UpdateIndexImages("foo", "app", []index.Image{{Id: "abc"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := reload(h, old, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer i.close()
+
+	old.close()
+
+	images, err := i.index.GetIndexImages("foo", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 1 || images[0].Id != "abc" {
+		t.Fatalf("index lost on reload: %+v", images)
+	}
+}
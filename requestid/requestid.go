@@ -0,0 +1,51 @@
+// Package requestid carries the id of the request being served in a context.Context,
+// so that drivers serving it, plugins included, log with the id
+package requestid
+
+import (
+	"context"
+	"log/slog"
+)
+
+type key struct{}
+
+// With returns ctx carrying id
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// From returns the id ctx carries, empty if none or ctx is nil
+func From(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(key{}).(string)
+
+	return id
+}
+
+// Handler adds request_id to records logged with a context carrying one, say, by slog.InfoContext
+type Handler struct {
+	slog.Handler
+}
+
+func NewHandler(h slog.Handler) *Handler {
+	return &Handler{h}
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := From(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{h.Handler.WithGroup(name)}
+}
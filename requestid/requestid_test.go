@@ -0,0 +1,30 @@
+package requestid
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+
+	var b bytes.Buffer
+
+	l := slog.New(NewHandler(slog.NewTextHandler(&b, nil))).With("driver", "test")
+
+	l.InfoContext(With(context.Background(), "abc"), "with id")
+
+	if s := b.String(); !strings.Contains(s, "request_id=abc") || !strings.Contains(s, "driver=test") {
+		t.Fatalf("got %q", s)
+	}
+
+	b.Reset()
+
+	l.Info("without id")
+
+	if s := b.String(); strings.Contains(s, "request_id") {
+		t.Fatalf("got %q", s)
+	}
+}
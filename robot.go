@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/pkg/mflag"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/robot"
+)
+
+const robotUsage = `Usage: docker-wicket --robot_file=FILE robot COMMAND
+
+Commands:
+  create --name=NAME --grant=foo/*:pull,push [--expires=720h] [--description=TEXT]
+  list
+  delete NAME
+`
+
+func robotCommand(args []string) error {
+
+	if robotFile == "" {
+		return fmt.Errorf("robot: --robot_file not set")
+	}
+
+	store := robot.NewFileStore(robotFile)
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, robotUsage)
+		return fmt.Errorf("robot: missing command")
+	}
+
+	switch args[0] {
+	case "create":
+		return robotCreate(store, args[1:])
+	case "list":
+		return robotList(store)
+	case "delete":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, robotUsage)
+			return fmt.Errorf("robot: delete needs a name")
+		}
+
+		return store.Delete(args[1])
+	}
+
+	fmt.Fprint(os.Stderr, robotUsage)
+	return fmt.Errorf("robot: unknown command %v", args[0])
+}
+
+func robotCreate(store robot.Store, args []string) error {
+
+	var name, description string
+	var expires time.Duration
+	var grants acl.Grants
+
+	fs := mflag.NewFlagSet("robot create", mflag.ExitOnError)
+	fs.StringVar(&name, []string{"-name"}, "", "Name of the robot, login as robot$NAME")
+	fs.StringVar(&description, []string{"-description"}, "", "What the robot is for")
+	fs.DurationVar(&expires, []string{"-expires"}, 0, "Robot expires after, 0 means never")
+	fs.Var(&grants, []string{"-grant"}, "Repository pattern and actions, e.g. foo/*:pull,push, can be repeated")
+	fs.Parse(args)
+
+	r, secret, err := robot.New(name, grants, expires)
+	if err != nil {
+		return err
+	}
+
+	r.Description = description
+
+	if err := store.Create(r); err != nil {
+		return err
+	}
+
+	fmt.Printf("username: %v\n", r.Username())
+	fmt.Printf("password: %v\n", secret)
+
+	return nil
+}
+
+func robotList(store robot.Store) error {
+
+	l, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, "USERNAME\tGRANTS\tEXPIRES\tDESCRIPTION")
+
+	for _, r := range l {
+		expires := "never"
+
+		if r.Expires != 0 {
+			expires = time.Unix(r.Expires, 0).Format(time.RFC3339)
+		}
+
+		grants := make([]string, len(r.Grants))
+
+		for i, g := range r.Grants {
+			grants[i] = g.String()
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", r.Username(), strings.Join(grants, " "), expires, r.Description)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,95 @@
+package robot
+
+import (
+	"context"
+
+	"github.com/tg123/docker-wicket/acl"
+)
+
+// Driver puts robots in front of any acl.Driver
+// usernames with Prefix are robots, others go to the wrapped driver
+type Driver struct {
+	acl.Driver
+
+	Store Store
+}
+
+func Wrap(store Store, driver acl.Driver) *Driver {
+	return &Driver{
+		Driver: driver,
+		Store:  store,
+	}
+}
+
+func (d *Driver) robot(username acl.Username) (*Robot, error) {
+	name, _ := NameOf(username)
+
+	r, err := d.Store.Get(name)
+
+	if err != nil || r == nil || r.Expired() {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (d *Driver) Authenticate(cred *acl.Credential) (acl.Session, error) {
+
+	if _, ok := NameOf(cred.Username); !ok {
+		return acl.Login(d.Driver, cred)
+	}
+
+	ok, err := d.CanLogin(cred.Username, cred.Password)
+
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return acl.NewSession(d, cred.Username), nil
+}
+
+func (d *Driver) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+
+	if _, ok := NameOf(username); !ok {
+		return d.Driver.CanLogin(username, password)
+	}
+
+	r, err := d.robot(username)
+
+	if err != nil || r == nil {
+		return false, err
+	}
+
+	return r.Match(string(password)), nil
+}
+
+func (d *Driver) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+
+	if _, ok := NameOf(username); !ok {
+		return d.Driver.CanAccess(username, namespace, repo, perm)
+	}
+
+	r, err := d.robot(username)
+
+	if err != nil || r == nil {
+		return false, err
+	}
+
+	return r.Grants.Allows(namespace, repo, perm), nil
+}
+
+func (d *Driver) WithContext(ctx context.Context) acl.Driver {
+	return Wrap(d.Store, acl.WithContext(d.Driver, ctx))
+}
+
+func (d *Driver) Close() error {
+	return acl.Close(d.Driver)
+}
+
+func (d *Driver) Check() error {
+	if _, err := d.Store.List(); err != nil {
+		return err
+	}
+
+	return acl.Check(d.Driver)
+}
@@ -0,0 +1,155 @@
+package robot
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tg123/docker-wicket/acl"
+)
+
+// users log in with their password and own the namespace of their name
+type users map[acl.Username]acl.Password
+
+func (u users) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	p, ok := u[username]
+	return ok && p == password, nil
+}
+
+func (u users) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	return username != acl.Anonymous && string(username) == namespace, nil
+}
+
+func newDriver(t *testing.T) *Driver {
+	return Wrap(NewFileStore(filepath.Join(t.TempDir(), "robots.json")), users{"user1": "pass1"})
+}
+
+// create creates robot name with grants, returns its secret
+func create(t *testing.T, d *Driver, name string, ttl time.Duration, grants ...string) (*Robot, string) {
+
+	var gs acl.Grants
+
+	for _, g := range grants {
+		if err := gs.Set(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, secret, err := New(name, gs, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Store.Create(r); err != nil {
+		t.Fatal(err)
+	}
+
+	return r, secret
+}
+
+func login(t *testing.T, d *Driver, username acl.Username, password string) acl.Session {
+
+	s, err := d.Authenticate(&acl.Credential{Username: username, Password: acl.Password(password)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestSecret(t *testing.T) {
+
+	d := newDriver(t)
+
+	r, secret := create(t, d, "ci", 0, "foo/*:pull")
+
+	if r.Username() != "robot$ci" {
+		t.Fatalf("username %v", r.Username())
+	}
+
+	s := login(t, d, "robot$ci", secret)
+
+	if s == nil {
+		t.Fatal("secret rejected")
+	}
+
+	for _, c := range []struct {
+		namespace string
+		repo      string
+		perm      acl.Permission
+		want      bool
+	}{
+		{"foo", "app", acl.READ, true},
+		{"foo", "app", acl.WRITE, false},
+		{"bar", "app", acl.READ, false},
+	} {
+		got, err := s.CanAccess(c.namespace, c.repo, c.perm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != c.want {
+			t.Errorf("%v/%v %v: got %v, want %v", c.namespace, c.repo, c.perm, got, c.want)
+		}
+	}
+
+	for _, c := range []struct {
+		username acl.Username
+		password string
+	}{
+		{"robot$ci", secret + "x"},
+		{"robot$ci", ""},
+		{"robot$other", secret},
+
+		// a robot is not its name without the prefix
+		{"ci", secret},
+	} {
+		if login(t, d, c.username, c.password) != nil {
+			t.Errorf("%v/%v accepted", c.username, c.password)
+		}
+	}
+
+	// others go to the wrapped driver
+	if login(t, d, "user1", "pass1") == nil {
+		t.Fatal("user1 rejected")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+
+	d := newDriver(t)
+
+	r, secret := create(t, d, "ci", time.Hour, "foo/*:pull")
+
+	if login(t, d, "robot$ci", secret) == nil {
+		t.Fatal("robot rejected before it expires")
+	}
+
+	// expire it
+	if err := d.Store.Delete(r.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Expires = time.Now().Add(-time.Second).Unix()
+
+	if err := d.Store.Create(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if login(t, d, "robot$ci", secret) != nil {
+		t.Fatal("expired robot accepted")
+	}
+
+	// nor can an expired robot access what it was granted, say, with a token issued before
+	if ok, err := d.CanAccess("robot$ci", "foo", "app", acl.READ); err != nil || ok {
+		t.Fatalf("expired robot can access: %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, bad := range []string{"", "CI", "ci$", "-ci", "a/b"} {
+		if _, _, err := New(bad, nil, 0); err == nil {
+			t.Errorf("robot %q created", bad)
+		}
+	}
+}
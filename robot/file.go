@@ -0,0 +1,54 @@
+package robot
+
+import (
+	"sort"
+
+	"github.com/tg123/docker-wicket/credential"
+)
+
+// FileStore keeps all robots in one json file
+// the file is read every time, so changes made by `docker-wicket robot` take effect without restart
+type FileStore struct {
+	file *credential.File[Robot]
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{credential.NewFile[Robot](path, "robot")}
+}
+
+func (s *FileStore) List() ([]*Robot, error) {
+
+	m, err := s.file.All()
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := make([]*Robot, 0, len(m))
+
+	for _, r := range m {
+		l = append(l, r)
+	}
+
+	sort.Sort(byName(l))
+
+	return l, nil
+}
+
+func (s *FileStore) Get(name string) (*Robot, error) {
+	return s.file.Get(name)
+}
+
+func (s *FileStore) Create(r *Robot) error {
+	return s.file.Create(r.Name, r)
+}
+
+func (s *FileStore) Delete(name string) error {
+	return s.file.Delete(name)
+}
+
+type byName []*Robot
+
+func (l byName) Len() int           { return len(l) }
+func (l byName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l byName) Less(i, j int) bool { return l[i].Name < l[j].Name }
@@ -0,0 +1,67 @@
+package robot
+
+// robot accounts, for CI and other machines which should not use a human's password
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/credential"
+)
+
+// robots login as Prefix + name, say, robot$ci
+const Prefix = "robot$"
+
+var validName = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+type Robot struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Grants      acl.Grants `json:"grants"`
+
+	credential.Secret
+}
+
+type Store interface {
+	List() ([]*Robot, error)
+
+	// Get returns nil if no such robot
+	Get(name string) (*Robot, error)
+
+	Create(r *Robot) error
+
+	Delete(name string) error
+}
+
+// New creates a robot and its secret, the secret is only known by the caller, robot keeps only the hash
+func New(name string, grants acl.Grants, ttl time.Duration) (*Robot, string, error) {
+
+	if !validName.MatchString(name) {
+		return nil, "", fmt.Errorf("invalid robot name %q", name)
+	}
+
+	secret, password, err := credential.NewSecret(ttl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &Robot{Name: name, Grants: grants, Secret: secret}, password, nil
+}
+
+func (r *Robot) Username() acl.Username {
+	return acl.Username(Prefix + r.Name)
+}
+
+// NameOf returns the robot name of a username, false if the username is not a robot's
+func NameOf(username acl.Username) (string, bool) {
+	s := string(username)
+
+	if !strings.HasPrefix(s, Prefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(s, Prefix), true
+}
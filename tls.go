@@ -0,0 +1,255 @@
+package main
+
+// https serving, the serving certificate can be replaced without restart
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func tlsCipherSuites(names string) ([]uint16, error) {
+
+	known := make(map[string]uint16)
+
+	for _, c := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[c.Name] = c.ID
+	}
+
+	var ids []uint16
+
+	for _, n := range splitList(names) {
+		id, ok := known[n]
+
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %v", n)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func tlsConfig() (*tls.Config, error) {
+
+	v, ok := tlsVersions[tlsMinVersion]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown tls version %v", tlsMinVersion)
+	}
+
+	ciphers, err := tlsCipherSuites(tlsCiphers)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := newCertReloader(tlsCertPath, tlsKeyPath, tlsClientCAPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     v,
+		CipherSuites:   ciphers,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if tlsClientCAPath == "" {
+		return config, nil
+	}
+
+	// clients without certificate still can use Basic auth
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+
+	// the CA pool is reloaded as the cert is, each handshake takes the latest
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = certs.ClientCAs()
+
+		return c, nil
+	}
+
+	return config, nil
+}
+
+// tlsSettings are what https is served with, only files they point to are reloaded
+type tlsSettings struct {
+	cert, key, minVersion, ciphers, clientCA string
+}
+
+// settings serve started with
+var servingTLS tlsSettings
+
+func currentTLSSettings() tlsSettings {
+	return tlsSettings{tlsCertPath, tlsKeyPath, tlsMinVersion, tlsCiphers, tlsClientCAPath}
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificate found in %v", file)
+	}
+
+	return pool, nil
+}
+
+// certReloader serves the latest good cert and key, and client CAs if caPath is set,
+// connections already established are not affected by a reload
+type certReloader struct {
+	certPath string
+	keyPath  string
+	caPath   string
+
+	lock sync.RWMutex
+	cert *tls.Certificate
+	cas  *x509.CertPool
+}
+
+func newCertReloader(certPath, keyPath, caPath string) (*certReloader, error) {
+
+	r := &certReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		caPath:   caPath,
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	go r.watch()
+
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return err
+	}
+
+	var cas *x509.CertPool
+
+	if r.caPath != "" {
+		cas, err = loadCertPool(r.caPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.lock.Lock()
+	r.cert = &cert
+	r.cas = cas
+	r.lock.Unlock()
+
+	return nil
+}
+
+func (r *certReloader) ClientCAs() *x509.CertPool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.cas
+}
+
+func (r *certReloader) files() []string {
+	if r.caPath == "" {
+		return []string{r.certPath, r.keyPath}
+	}
+
+	return []string{r.certPath, r.keyPath, r.caPath}
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.cert, nil
+}
+
+// reload on SIGHUP, or when files change
+// dirs are watched instead of files, so renaming a new file over the old one is seen
+func (r *certReloader) watch() {
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var events chan fsnotify.Event
+	var errs chan error
+
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		slog.Warn("Cannot watch tls cert, reload on SIGHUP only", "err", err)
+	} else {
+		for _, p := range r.files() {
+			if err := watcher.Add(filepath.Dir(p)); err != nil {
+				slog.Warn("Cannot watch tls cert, reload on SIGHUP only", "path", p, "err", err)
+			}
+		}
+
+		events = watcher.Events
+		errs = watcher.Errors
+	}
+
+	for {
+		select {
+		case <-hup:
+		case err := <-errs:
+			// fsnotify blocks on a full Errors channel, say, queue overflow
+			slog.Warn("Error watching tls cert", "err", err)
+			continue
+		case e := <-events:
+			if !r.concerns(e.Name) || e.Op&fsnotify.Chmod == e.Op {
+				continue
+			}
+		}
+
+		if err := r.reload(); err != nil {
+			// half written files are expected, keep serving the old one
+			slog.Warn("Cannot reload tls cert, keep using the old one", "err", err)
+			continue
+		}
+
+		slog.Info("Reloaded tls cert", "path", r.certPath, "client_ca", r.caPath)
+	}
+}
+
+func (r *certReloader) concerns(name string) bool {
+
+	for _, p := range r.files() {
+		if filepath.Clean(name) == filepath.Clean(p) {
+			return true
+		}
+	}
+
+	// kubernetes secrets are swapped via ..data symlink
+	return strings.HasPrefix(filepath.Base(name), "..")
+}
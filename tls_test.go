@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self signed cert and its key named cn in dir
+func writeCert(t *testing.T, dir, cn string) (string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, cn+".crt")
+	keyFile := filepath.Join(dir, cn+".key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func clientCAs(t *testing.T, c *tls.Config) *x509.CertPool {
+
+	if c.GetConfigForClient == nil {
+		t.Fatal("client CAs are not reloadable")
+	}
+
+	cc, err := c.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cc.ClientAuth != tls.VerifyClientCertIfGiven || cc.GetCertificate == nil {
+		t.Fatal("config for client lost settings")
+	}
+
+	return cc.ClientCAs
+}
+
+func TestTLSClientCAReload(t *testing.T) {
+
+	dir := t.TempDir()
+
+	certFile, keyFile := writeCert(t, dir, "server")
+	oldCA, _ := writeCert(t, dir, "old-ca")
+	newCA, _ := writeCert(t, dir, "new-ca")
+
+	caFile := filepath.Join(dir, "ca.crt")
+
+	copyFile := func(from string) {
+		b, err := os.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(caFile, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	copyFile(oldCA)
+
+	tlsCertPath, tlsKeyPath, tlsClientCAPath, tlsMinVersion = certFile, keyFile, caFile, "1.2"
+
+	defer func() {
+		tlsCertPath, tlsKeyPath, tlsClientCAPath = "", "", ""
+	}()
+
+	c, err := tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, _ := os.ReadFile(oldCA)
+	oldPool := x509.NewCertPool()
+	oldPool.AppendCertsFromPEM(old)
+
+	if !clientCAs(t, c).Equal(oldPool) {
+		t.Fatal("client CAs are not of the CA file")
+	}
+
+	// the watcher picks the change up, written again as it may start watching after the first write
+	deadline := time.Now().Add(5 * time.Second)
+
+	for clientCAs(t, c).Equal(oldPool) {
+		if time.Now().After(deadline) {
+			t.Fatal("client CAs not reloaded")
+		}
+
+		copyFile(newCA)
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+// offline token tools, mostly for debugging
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/distribution/registry/auth/token"
+	"github.com/docker/docker/pkg/mflag"
+
+	"github.com/tg123/docker-wicket/handler"
+)
+
+const tokenUsage = `Usage: docker-wicket [OPTIONS] token COMMAND
+
+Commands:
+  issue  --sub=ACCOUNT --scope=repository:foo/bar:pull    sign a token with --cert and --key
+  verify [TOKEN]                                          verify a token against --cert and print its claims
+  decode [TOKEN]                                          print claims of a token, verify it if --cert is set
+
+TOKEN is read from stdin if omitted
+`
+
+func tokenCommand(args []string) error {
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, tokenUsage)
+		return fmt.Errorf("token: missing command")
+	}
+
+	switch args[0] {
+	case "issue":
+		return tokenIssue(args[1:])
+	case "verify":
+		return tokenInspect(args[1:], true)
+	case "decode":
+		return tokenInspect(args[1:], false)
+	}
+
+	fmt.Fprint(os.Stderr, tokenUsage)
+	return fmt.Errorf("token: unknown command %v", args[0])
+}
+
+func tokenIssue(args []string) error {
+
+	var sub, scope, aud string
+
+	fs := mflag.NewFlagSet("token issue", mflag.ExitOnError)
+	fs.StringVar(&sub, []string{"-sub"}, "", "Subject (account) of the token")
+	fs.StringVar(&scope, []string{"-scope"}, "", "Scope of the token, e.g. repository:foo/bar:pull,push")
+	fs.StringVar(&aud, []string{"-aud"}, "", "Audience of the token, default to --service")
+	fs.Parse(args)
+
+	tokenAuth := newTokenAuth()
+
+	if err := tokenAuth.LoadCertAndKey(certPath, certKeyPath); err != nil {
+		return fmt.Errorf("Cannot load cert: %v", err)
+	}
+
+	ar := &handler.AuthRequest{
+		Account: sub,
+		Service: aud,
+	}
+
+	if ar.Service == "" {
+		ar.Service = tokenAuth.Service
+	}
+
+	// same format as scope in GET /v2/token/
+	if scope != "" {
+		parts := strings.Split(scope, ":")
+		if len(parts) != 3 {
+			return fmt.Errorf("invalid scope: %q", scope)
+		}
+
+		ar.Type = parts[0]
+		ar.Name = parts[1]
+		ar.Actions = strings.Split(parts[2], ",")
+	}
+
+	t, err := tokenAuth.CreateToken(ar)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(t)
+
+	return nil
+}
+
+func tokenInspect(args []string, mustVerify bool) error {
+
+	var raw string
+
+	if len(args) > 0 && args[0] != "-" {
+		raw = args[0]
+	} else {
+		s := bufio.NewScanner(os.Stdin)
+		s.Scan()
+
+		if err := s.Err(); err != nil {
+			return err
+		}
+
+		raw = s.Text()
+	}
+
+	t, err := token.NewToken(strings.TrimSpace(raw))
+	if err != nil {
+		return err
+	}
+
+	for _, v := range []interface{}{t.Header, t.Claims} {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(b))
+	}
+
+	if certPath == "" && !mustVerify {
+		return nil
+	}
+
+	tokenAuth := newTokenAuth()
+
+	if err := tokenAuth.LoadCert(certPath); err != nil {
+		return fmt.Errorf("Cannot load cert: %v", err)
+	}
+
+	if err := t.Verify(tokenAuth.VerifyOptions()); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+
+	fmt.Println("verification ok")
+
+	return nil
+}
@@ -0,0 +1,129 @@
+// Package tracing sets up OpenTelemetry tracing with W3C trace context propagation
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gocraft/web"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/tg123/docker-wicket"
+
+func noop(context.Context) error {
+	return nil
+}
+
+// Setup installs a tracer provider exporting to exporter, none, stdout or otlp
+// endpoint is the otlp http url, empty to use OTEL_EXPORTER_OTLP_* env
+// the returned func flushes pending spans and stops the exporter
+func Setup(exporter, endpoint, service string) (func(context.Context) error, error) {
+
+	// trace context is always propagated, even nothing is exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exp sdktrace.SpanExporter
+	var err error
+
+	switch exporter {
+	case "", "none":
+		return noop, nil
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		var opts []otlptracehttp.Option
+
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+
+		exp, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, want none, stdout or otlp", exporter)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// Start starts a span as child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Span starts a span as child of req's and makes it req's span,
+// so that spans started later in the middleware chain nest in it
+func Span(req *web.Request, name string, attrs ...attribute.KeyValue) trace.Span {
+	ctx, span := Start(req.Context(), name, attrs...)
+
+	req.Request = req.Request.WithContext(ctx)
+
+	return span
+}
+
+// Fail marks span as failed with err
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// End ends span, marks it failed if err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		Fail(span, err)
+	}
+
+	span.End()
+}
+
+// Middleware starts a server span for each request,
+// continuing the trace from traceparent header if any
+func Middleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+	ctx, span := otel.Tracer(instrumentation).Start(ctx, req.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+
+	defer span.End()
+
+	req.Request = req.Request.WithContext(ctx)
+
+	next(rw, req)
+
+	// route is known only after routing
+	if route := req.RoutePath(); route != "" {
+		span.SetName(req.Method + " " + route)
+		span.SetAttributes(attribute.String("http.route", route))
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", rw.StatusCode()))
+
+	if rw.StatusCode() >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(rw.StatusCode()))
+	}
+}
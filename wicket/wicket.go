@@ -0,0 +1,196 @@
+// Package wicket serves docker registry tokens as an http.Handler, for programs embedding wicket
+//
+//	h, err := wicket.New(wicket.Config{
+//		CertFile: "token.crt",
+//		KeyFile:  "token.key",
+//		ACL:      myDriver,
+//	})
+//
+//	mux.Handle("/v2/token/", h)
+//
+// routes are /v2/token/, /v1/... if Index is set, /api/... if Robots or Tokens is set and /healthz,
+// use http.StripPrefix to mount them under a prefix
+package wicket
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/audit"
+	"github.com/tg123/docker-wicket/handler"
+	"github.com/tg123/docker-wicket/handler/api"
+	"github.com/tg123/docker-wicket/handler/health"
+	"github.com/tg123/docker-wicket/handler/v1"
+	"github.com/tg123/docker-wicket/handler/v2"
+	"github.com/tg123/docker-wicket/index"
+	"github.com/tg123/docker-wicket/pat"
+	"github.com/tg123/docker-wicket/robot"
+	"github.com/tg123/docker-wicket/tracing"
+)
+
+// Config is what main.go gets from flags, zero values are the defaults of those flags
+type Config struct {
+	// token, Issuer MUST be same as what in registry2
+	Issuer     string        // docker-wicket
+	Service    string        // registry
+	Expiration time.Duration // 10 minutes
+
+	// cert and key to sign tokens, cert MUST be in the bundle of registry2
+	CertFile string
+	KeyFile  string
+
+	ACL acl.Driver
+
+	// nil not to serve v1
+	Index      index.Driver
+	V1Endpoint string
+
+	// nil to disable robots or personal access tokens, Admins can manage robots and backup the index
+	Robots robot.Store
+	Tokens pat.Store
+	Admins []string
+
+	// templates mapping verified client certificates to usernames, say, {{.Subject.CommonName}}
+	// empty to ignore client certificates, which are verified by the server's tls.Config
+	ClientCertUsernames []string
+
+	// CIDRs of proxies in front, whose X-Real-IP and X-Forwarded-For are believed, others' are ignored
+	TrustedProxies []string
+
+	// where audit events go, closed by the caller
+	AuditSinks []audit.Sink
+
+	Hooks Hooks
+}
+
+// Hooks are called while serving, nil ones are skipped
+type Hooks struct {
+	// OnEvent is called with every login, access decision and token issued, after AuditSinks
+	OnEvent func(e *audit.Event)
+}
+
+func (c *Config) tokenAuth() (*handler.TokenAuth, error) {
+
+	t := &handler.TokenAuth{
+		Issuer:     c.Issuer,
+		Service:    c.Service,
+		Expiration: int64(c.Expiration / time.Second),
+	}
+
+	if t.Issuer == "" {
+		t.Issuer = "docker-wicket"
+	}
+
+	if t.Service == "" {
+		t.Service = "registry"
+	}
+
+	if t.Expiration <= 0 {
+		t.Expiration = 600
+	}
+
+	if err := t.LoadCertAndKey(c.CertFile, c.KeyFile); err != nil {
+		return nil, fmt.Errorf("cannot load cert: %v", err)
+	}
+
+	return t, nil
+}
+
+func (c *Config) audit() *audit.Logger {
+
+	sinks := c.AuditSinks
+
+	if c.Hooks.OnEvent != nil {
+		sinks = append(sinks[:len(sinks):len(sinks)], audit.SinkFunc(func(e *audit.Event) error {
+			c.Hooks.OnEvent(e)
+			return nil
+		}))
+	}
+
+	return audit.New(sinks...)
+}
+
+// New builds the handler, c is not used after
+func New(c Config) (http.Handler, error) {
+
+	if c.ACL == nil {
+		return nil, fmt.Errorf("no ACL driver")
+	}
+
+	tokenAuth, err := c.tokenAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	acldriver := c.ACL
+
+	if c.Robots != nil {
+		acldriver = robot.Wrap(c.Robots, acldriver)
+	}
+
+	if c.Tokens != nil {
+		acldriver = pat.Wrap(c.Tokens, acldriver)
+	}
+
+	rc := handler.RunningContext{
+		TokenAuth: tokenAuth,
+		Acl:       acldriver,
+		Audit:     c.audit(),
+	}
+
+	if len(c.ClientCertUsernames) > 0 {
+		rc.ClientCerts, err = handler.NewCertIdentity(c.ClientCertUsernames)
+		if err != nil {
+			return nil, fmt.Errorf("bad client username template: %v", err)
+		}
+	}
+
+	proxies, err := handler.ParseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	router := web.New(handler.ShareWebContext{}).
+		Middleware(handler.RealIP(proxies)).
+		Middleware(tracing.Middleware).
+		Middleware((*handler.ShareWebContext).RequestLogger)
+
+	v2.InstallHandler(router, &v2.RunningContext{
+		RunningContext: rc,
+	})
+
+	if c.Index != nil {
+		v1.InstallHandler(router, &v1.RunningContext{
+			RunningContext: rc,
+			Endpoints:      c.V1Endpoint,
+			Index:          c.Index,
+		})
+	}
+
+	if c.Robots != nil || c.Tokens != nil {
+		api.InstallHandler(router, &api.RunningContext{
+			RunningContext: rc,
+			Admins:         c.Admins,
+			Robots:         c.Robots,
+			Tokens:         c.Tokens,
+			Index:          c.Index,
+		})
+	}
+
+	// always ready, the embedding program has its own readiness
+	readiness := &handler.Readiness{}
+	readiness.Set(true)
+
+	health.InstallHandler(router, &health.RunningContext{
+		RunningContext:    rc,
+		Index:             c.Index,
+		Readiness:         readiness,
+		CertExpiryWarning: 7 * 24 * time.Hour,
+	})
+
+	return router, nil
+}
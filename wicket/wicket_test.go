@@ -0,0 +1,594 @@
+package wicket
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/tg123/docker-wicket/acl"
+	"github.com/tg123/docker-wicket/audit"
+	"github.com/tg123/docker-wicket/index/mem"
+	"github.com/tg123/docker-wicket/pat"
+	"github.com/tg123/docker-wicket/requestid"
+)
+
+// writeCert writes a self signed token cert and key, returns their paths
+func writeCert(t *testing.T) (string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "wicket"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	certFile := filepath.Join(dir, "token.crt")
+	keyFile := filepath.Join(dir, "token.key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+// users log in with their password and own the namespace of their name
+type users map[acl.Username]acl.Password
+
+func (u users) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	p, ok := u[username]
+	return ok && p == password, nil
+}
+
+func (u users) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	return username != acl.Anonymous && string(username) == namespace, nil
+}
+
+// recorder keeps events from Hooks.OnEvent
+type recorder struct {
+	lock   sync.Mutex
+	events []audit.Event
+}
+
+func (r *recorder) record(e *audit.Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.events = append(r.events, *e)
+}
+
+// take returns events so far and forgets them
+func (r *recorder) take() []audit.Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	l := r.events
+	r.events = nil
+
+	return l
+}
+
+func newHandler(t *testing.T, r *recorder) http.Handler {
+
+	certFile, keyFile := writeCert(t)
+
+	h, err := New(Config{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		ACL:      users{"user1": "pass1", "user2": "pass2"},
+		Index:    mem.New(),
+		Tokens:   pat.NewFileStore(filepath.Join(t.TempDir(), "tokens.json")),
+		Hooks:    Hooks{OnEvent: r.record},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+type request struct {
+	method   string
+	path     string
+	username string
+	password string
+	header   map[string]string
+	body     string
+}
+
+func (q *request) do(t *testing.T, h http.Handler) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(q.method, q.path, strings.NewReader(q.body))
+
+	if q.username != "" {
+		req.SetBasicAuth(q.username, q.password)
+	}
+
+	for k, v := range q.header {
+		req.Header.Set(k, v)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+
+	return rw
+}
+
+func expectEvent(t *testing.T, events []audit.Event, typ, api, method, result string) {
+	t.Helper()
+
+	if len(events) != 1 {
+		t.Fatalf("got %v events, want 1: %+v", len(events), events)
+	}
+
+	e := events[0]
+
+	if e.Type != typ || e.API != api || e.Method != method || e.Result != result {
+		t.Fatalf("got %v/%v/%v/%v, want %v/%v/%v/%v", e.Type, e.API, e.Method, e.Result, typ, api, method, result)
+	}
+}
+
+func TestAuditLogin(t *testing.T) {
+
+	r := &recorder{}
+	h := newHandler(t, r)
+
+	for _, c := range []struct {
+		request
+		status int
+		api    string
+		result string
+	}{
+		{request{method: "GET", path: "/v1/users/", username: "user1", password: "pass1"}, http.StatusOK, "v1", audit.Success},
+		{request{method: "GET", path: "/v1/users/", username: "user1", password: "wrong"}, http.StatusUnauthorized, "v1", audit.Failure},
+		{request{method: "GET", path: "/api/tokens/", username: "user1", password: "pass1"}, http.StatusOK, "api", audit.Success},
+		{request{method: "GET", path: "/api/tokens/", username: "user1", password: "wrong"}, http.StatusForbidden, "api", audit.Failure},
+	} {
+		rw := c.do(t, h)
+
+		if rw.Code != c.status {
+			t.Fatalf("%v %v as %v/%v: got %v, want %v", c.method, c.path, c.username, c.password, rw.Code, c.status)
+		}
+
+		expectEvent(t, r.take(), audit.Login, c.api, "basic", c.result)
+	}
+
+	// anonymous can not login, and is not a login attempt
+	if rw := (&request{method: "GET", path: "/v1/users/"}).do(t, h); rw.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous login: got %v, want %v", rw.Code, http.StatusUnauthorized)
+	}
+
+	if events := r.take(); len(events) != 0 {
+		t.Fatalf("anonymous login audited: %+v", events)
+	}
+}
+
+func TestAuditV1Token(t *testing.T) {
+
+	r := &recorder{}
+	h := newHandler(t, r)
+
+	rw := (&request{
+		method:   "PUT",
+		path:     "/v1/repositories/user1/app/",
+		username: "user1",
+		password: "pass1",
+		header:   map[string]string{"X-Docker-Token": "true"},
+		body:     "[]",
+	}).do(t, h)
+
+	if rw.Code/100 != 2 {
+		t.Fatalf("push: got %v", rw.Code)
+	}
+
+	token := rw.Header().Get("X-Docker-Token")
+
+	if token == "" {
+		t.Fatal("no token issued")
+	}
+
+	r.take()
+
+	auth := map[string]string{"Authorization": "Token " + token}
+
+	if rw := (&request{method: "PUT", path: "/v1/repositories/user1/app/images", header: auth, body: "[]"}).do(t, h); rw.Code/100 != 2 {
+		t.Fatalf("images with token: got %v", rw.Code)
+	}
+
+	expectEvent(t, r.take(), audit.Access, "v1", "token", audit.Success)
+
+	// the token is for user1/app only, whatever repository the header says
+	if rw := (&request{method: "PUT", path: "/v1/repositories/user2/app/images", header: auth, body: "[]"}).do(t, h); rw.Code/100 == 2 {
+		t.Fatalf("images of another repo with token: got %v", rw.Code)
+	}
+
+	events := r.take()
+
+	if len(events) == 0 {
+		t.Fatal("token check not audited")
+	}
+
+	expectEvent(t, events[:1], audit.Access, "v1", "token", audit.Failure)
+}
+
+// counting counts access checks
+type counting struct {
+	users
+	checks atomic.Int64
+}
+
+func (c *counting) CanAccess(username acl.Username, namespace, repo string, perm acl.Permission) (bool, error) {
+	c.checks.Add(1)
+	return c.users.CanAccess(username, namespace, repo, perm)
+}
+
+func TestSearch(t *testing.T) {
+
+	certFile, keyFile := writeCert(t)
+
+	a := &counting{users: users{"user1": "pass1"}}
+	idx := mem.New()
+
+	for _, name := range []string{"user1/a", "user1/b", "user1/c", "user1/d", "user1/e", "user1/f", "user2/a", "user2/b"} {
+		namespace, repo, _ := strings.Cut(name, "/")
+
+		if err := idx.CreateRepo(namespace, repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := New(Config{CertFile: certFile, KeyFile: keyFile, ACL: a, Index: idx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := (&request{method: "GET", path: "/v1/search?q=&n=2&page=2", username: "user1", password: "pass1"}).do(t, h)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("search: got %v", rw.Code)
+	}
+
+	var r struct {
+		NumPages   int `json:"num_pages"`
+		NumResults int `json:"num_results"`
+		Results    []struct {
+			Name string `json:"name"`
+		} `json:"results"`
+	}
+
+	if err := json.Unmarshal(rw.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Results) != 2 || r.Results[0].Name != "user1/c" || r.Results[1].Name != "user1/d" {
+		t.Fatalf("page 2 is %+v", r.Results)
+	}
+
+	// all user1 can read, user2's repos checked and left out
+	if r.NumResults != 6 || r.NumPages != 3 {
+		t.Fatalf("got %v results in %v pages, want 6 in 3", r.NumResults, r.NumPages)
+	}
+
+	if n := a.checks.Load(); n != 8 {
+		t.Fatalf("%v access checks, want 8", n)
+	}
+
+	// anonymous logs in like anyone, the driver does not let it
+	if rw := (&request{method: "GET", path: "/v1/search?q="}).do(t, h); rw.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous search: got %v, want %v", rw.Code, http.StatusUnauthorized)
+	}
+}
+
+// binding records the request id of the request it serves on login
+type binding struct {
+	users
+
+	seen *string
+	id   string
+}
+
+func (b *binding) WithContext(ctx context.Context) acl.Driver {
+	return &binding{b.users, b.seen, requestid.From(ctx)}
+}
+
+func (b *binding) CanLogin(username acl.Username, password acl.Password) (bool, error) {
+	*b.seen = b.id
+	return b.users.CanLogin(username, password)
+}
+
+func TestRequestIDToDriver(t *testing.T) {
+
+	certFile, keyFile := writeCert(t)
+
+	var seen string
+
+	h, err := New(Config{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		ACL:      &binding{users: users{"user1": "pass1"}, seen: &seen},
+		Index:    mem.New(),
+		Tokens:   pat.NewFileStore(filepath.Join(t.TempDir(), "tokens.json")),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/v1/users/", "/api/tokens/", "/v2/token?service=registry"} {
+		seen = ""
+
+		rw := (&request{method: "GET", path: path, username: "user1", password: "pass1", header: map[string]string{"X-Request-ID": "abc"}}).do(t, h)
+
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%v: got %v", path, rw.Code)
+		}
+
+		if seen != "abc" {
+			t.Fatalf("%v: driver got request id %q", path, seen)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+
+	spans := tracetest.NewSpanRecorder()
+
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+
+	defer otel.SetTracerProvider(old)
+
+	h := newHandler(t, &recorder{})
+
+	for _, c := range []struct {
+		path  string
+		spans []string
+	}{
+		{"/v1/search?q=user1", []string{"index.Search", "acl.CanAccess"}},
+		{"/api/tokens/", []string{"pat.List"}},
+	} {
+		if rw := (&request{method: "GET", path: c.path, username: "user1", password: "pass1"}).do(t, h); rw.Code != http.StatusOK {
+			t.Fatalf("%v: got %v", c.path, rw.Code)
+		}
+
+		ended := make(map[string]bool)
+
+		for _, s := range spans.Ended() {
+			ended[s.Name()] = true
+		}
+
+		for _, name := range c.spans {
+			if !ended[name] {
+				t.Fatalf("%v: no span %v", c.path, name)
+			}
+		}
+	}
+}
+
+// bearers log in with any password as "token", granted by it, like oidc
+type bearers struct {
+	users
+}
+
+type bearerSession struct{}
+
+func (bearerSession) Username() acl.Username {
+	return "sub"
+}
+
+func (bearerSession) CanAccess(namespace, repo string, perm acl.Permission) (bool, error) {
+	return true, nil
+}
+
+func (b bearers) Authenticate(cred *acl.Credential) (acl.Session, error) {
+	if cred.Username == "token" {
+		return bearerSession{}, nil
+	}
+
+	return acl.Login(b.users, cred)
+}
+
+func TestTokenOwner(t *testing.T) {
+
+	certFile, keyFile := writeCert(t)
+
+	h, err := New(Config{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		ACL:      acl.Chain{bearers{users{"user1": "pass1"}}},
+		Tokens:   pat.NewFileStore(filepath.Join(t.TempDir(), "tokens.json")),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		username string
+		password string
+		status   int
+	}{
+		{"user1", "pass1", http.StatusCreated},
+		{"token", "anything", http.StatusForbidden},
+	} {
+		rw := (&request{method: "POST", path: "/api/tokens/", username: c.username, password: c.password, body: `{"grants": ["*:pull"]}`}).do(t, h)
+
+		if rw.Code != c.status {
+			t.Fatalf("token of %v: got %v, want %v", c.username, rw.Code, c.status)
+		}
+	}
+}
+
+// issuer returns iss of a jwt, not verified
+func issuer(t *testing.T, token string) string {
+
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		t.Errorf("bad token %q", token)
+		return ""
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+
+	if err := json.Unmarshal(b, &claims); err != nil {
+		t.Error(err)
+	}
+
+	return claims.Issuer
+}
+
+// two wickets in one process serve with their own settings and drivers, at the same time
+func TestParallelInstances(t *testing.T) {
+
+	type instance struct {
+		h        http.Handler
+		issuer   string
+		username string
+		other    string
+	}
+
+	var instances []*instance
+
+	for _, c := range []struct{ issuer, username, other string }{
+		{"wicket-a", "user1", "user2"},
+		{"wicket-b", "user2", "user1"},
+	} {
+		certFile, keyFile := writeCert(t)
+
+		h, err := New(Config{
+			Issuer:   c.issuer,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+			ACL:      users{acl.Username(c.username): "pass"},
+			Index:    mem.New(),
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		instances = append(instances, &instance{h, c.issuer, c.username, c.other})
+	}
+
+	var wg sync.WaitGroup
+
+	for _, in := range instances {
+		for i := range 10 {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				scope := "/v2/token?service=registry&scope=repository:" + in.username + "/app:pull"
+
+				rw := (&request{method: "GET", path: scope, username: in.username, password: "pass"}).do(t, in.h)
+
+				if rw.Code != http.StatusOK {
+					t.Errorf("%v: token of %v: got %v", in.issuer, in.username, rw.Code)
+					return
+				}
+
+				var token struct {
+					Token string `json:"token"`
+				}
+
+				if err := json.Unmarshal(rw.Body.Bytes(), &token); err != nil {
+					t.Error(err)
+					return
+				}
+
+				if iss := issuer(t, token.Token); iss != in.issuer {
+					t.Errorf("%v: token issued by %v", in.issuer, iss)
+				}
+
+				// users of the other one are unknown here
+				if rw := (&request{method: "GET", path: scope, username: in.other, password: "pass"}).do(t, in.h); rw.Code != http.StatusForbidden {
+					t.Errorf("%v: token of %v: got %v", in.issuer, in.other, rw.Code)
+				}
+
+				push := &request{
+					method:   "PUT",
+					path:     fmt.Sprintf("/v1/repositories/%v/app%v/", in.username, i),
+					username: in.username,
+					password: "pass",
+					body:     "[]",
+				}
+
+				if rw := push.do(t, in.h); rw.Code/100 != 2 {
+					t.Errorf("%v: push: got %v", in.issuer, rw.Code)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+
+	// each index has only its own pushes
+	for _, in := range instances {
+		rw := (&request{method: "GET", path: "/v1/search?q=app&n=100", username: in.username, password: "pass"}).do(t, in.h)
+
+		var r struct {
+			NumResults int `json:"num_results"`
+		}
+
+		if err := json.Unmarshal(rw.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+
+		if r.NumResults != 10 {
+			t.Fatalf("%v: %v repos, want 10", in.issuer, r.NumResults)
+		}
+	}
+}